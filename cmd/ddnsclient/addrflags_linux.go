@@ -0,0 +1,76 @@
+package main
+
+import (
+	"encoding/binary"
+	"net/netip"
+	"syscall"
+)
+
+// IFA_F_* address flags and the IFA_FLAGS attribute which carries all 32
+// bits of them, syscall only knows the 8 bit field of ifaddrmsg.
+const (
+	ifaFlags            = 8
+	ifaFTemporary       = 0x01
+	ifaFDadFailed       = 0x08
+	ifaFDeprecated      = 0x20
+	ifaFTentative       = 0x40
+	ifaFPermanent       = 0x80
+	ifaFStablePrivacy   = 0x800
+	ifaFManageTempAddrs = 0x100
+)
+
+// ipv6AddrFlags reads the flags of the IPv6 addresses from the kernel by
+// netlink, keyed by interface index and address.
+func ipv6AddrFlags() (map[interfaceAddrKey]addrFlags, error) {
+	rib, err := syscall.NetlinkRIB(syscall.RTM_GETADDR, syscall.AF_INET6)
+	if err != nil {
+		return nil, err
+	}
+	msgs, err := syscall.ParseNetlinkMessage(rib)
+	if err != nil {
+		return nil, err
+	}
+	flags := make(map[interfaceAddrKey]addrFlags)
+	for i := range msgs {
+		m := &msgs[i]
+		if m.Header.Type != syscall.RTM_NEWADDR || len(m.Data) < syscall.SizeofIfAddrmsg {
+			continue
+		}
+		// struct ifaddrmsg: family, prefixlen, flags, scope, index
+		index := binary.NativeEndian.Uint32(m.Data[4:8])
+		attrs, err := syscall.ParseNetlinkRouteAttr(m)
+		if err != nil {
+			return nil, err
+		}
+		var addr netip.Addr
+		raw := uint32(m.Data[2])
+		for _, attr := range attrs {
+			switch attr.Attr.Type {
+			case syscall.IFA_ADDRESS:
+				addr, _ = netip.AddrFromSlice(attr.Value)
+			case ifaFlags:
+				if len(attr.Value) >= 4 {
+					raw = binary.NativeEndian.Uint32(attr.Value)
+				}
+			}
+		}
+		if !addr.IsValid() {
+			continue
+		}
+		var f addrFlags
+		if raw&ifaFTemporary != 0 {
+			f |= addrTemporary
+		}
+		if raw&ifaFDeprecated != 0 {
+			f |= addrDeprecated
+		}
+		if raw&(ifaFTentative|ifaFDadFailed) != 0 {
+			f |= addrTentative
+		}
+		if raw&(ifaFPermanent|ifaFStablePrivacy|ifaFManageTempAddrs) != 0 {
+			f |= addrStable
+		}
+		flags[interfaceAddrKey{int(index), addr}] = f
+	}
+	return flags, nil
+}
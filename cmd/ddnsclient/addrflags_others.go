@@ -0,0 +1,9 @@
+//go:build !linux
+
+package main
+
+// ipv6AddrFlags isn't implemented on this platform, temporary and deprecated
+// addresses can't be told apart and only the scope and interface ID are used.
+func ipv6AddrFlags() (map[interfaceAddrKey]addrFlags, error) {
+	return nil, nil
+}
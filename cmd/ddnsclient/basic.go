@@ -1,14 +1,95 @@
 package main
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/missdeer/ddnsclient/models"
 )
 
-func basicAuthorizeHttpRequest(user string, password string, requestUrl string) error {
+func init() {
+	registerProvider("basic", newBasicAuthProvider)
+}
+
+// basicAuthProvider calls a dyndns style update URL protected by HTTP basic authorization.
+// The address of each record is passed in the myip parameter, the service
+// can't be asked for the current record, so it can only be updated.
+type basicAuthProvider struct {
+	item models.BasicAuthConfigurationItem
+}
+
+func newBasicAuthProvider(raw json.RawMessage) (Provider, error) {
+	p := &basicAuthProvider{}
+	if err := json.Unmarshal(raw, &p.item); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// Name is the host of the URL and its hostname parameter, URLs without one,
+// e.g. with a domains parameter or a token in the path, are told apart by a
+// hash of their path and sorted query so their secrets aren't logged.
+func (p *basicAuthProvider) Name() string {
+	u, err := url.Parse(p.item.Url)
+	if err != nil {
+		return "basic:" + p.item.Url
+	}
+	query := u.Query()
+	if hostname := query.Get("hostname"); len(hostname) != 0 {
+		return "basic:" + u.Host + "/" + hostname
+	}
+	sum := sha256.Sum256([]byte(u.Path + "?" + query.Encode()))
+	return "basic:" + u.Host + "/" + hex.EncodeToString(sum[:4])
+}
+
+func (p *basicAuthProvider) Options() *models.RecordOptions {
+	return &p.item.RecordOptions
+}
+
+func (p *basicAuthProvider) Validate() error {
+	if len(p.item.Url) == 0 {
+		return errors.New("missing url")
+	}
+	if _, err := url.ParseRequestURI(p.item.Url); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (p *basicAuthProvider) CurrentRecord(ctx context.Context, recordType string) (*Record, error) {
+	return nil, errNotSupported
+}
+
+func (p *basicAuthProvider) Upsert(ctx context.Context, current *Record, recordType string, value string) error {
+	u, err := url.Parse(p.item.Url)
+	if err != nil {
+		return validationError(err)
+	}
+	query := u.Query()
+	query.Set("myip", value)
+	u.RawQuery = query.Encode()
+	return basicAuthorizeHttpRequest(ctx, p.item.UserName, p.item.Password, u.String())
+}
+
+func (p *basicAuthProvider) Delete(ctx context.Context, recordType string) error {
+	return errNotSupported
+}
+
+func basicAuthorizeHttpRequest(ctx context.Context, user string, password string, requestUrl string) error {
 	client := &http.Client{}
-	req, err := http.NewRequest("GET", requestUrl, nil)
+	req, err := http.NewRequestWithContext(ctx, "GET", requestUrl, nil)
+	if err != nil {
+		fmt.Printf("create request %s failed\n", requestUrl)
+		return err
+	}
 	req.SetBasicAuth(user, password)
 	resp, err := client.Do(req)
 	if err != nil {
@@ -16,10 +97,33 @@ func basicAuthorizeHttpRequest(user string, password string, requestUrl string)
 		return err
 	}
 	defer resp.Body.Close()
-	_, err = ioutil.ReadAll(resp.Body)
+	if err = checkResponse(resp); err != nil {
+		return err
+	}
+	body, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
 		fmt.Printf("reading response failed\n")
 		return err
 	}
+	return basicAuthResult(string(body))
+}
+
+// basicAuthResult classifies the dyndns style return code in the response body.
+func basicAuthResult(body string) error {
+	fields := strings.Fields(body)
+	if len(fields) == 0 {
+		return nil
+	}
+	err := errors.New(strings.TrimSpace(body))
+	switch fields[0] {
+	case "badauth", "!donator":
+		return authError(err)
+	case "nohost":
+		return notFoundError(err)
+	case "notfqdn", "numhost", "abuse", "badagent", "badsys":
+		return validationError(err)
+	case "dnserr", "911":
+		return transientError(err, 0)
+	}
 	return nil
 }
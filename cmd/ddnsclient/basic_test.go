@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestBasicAuthUpsert(t *testing.T) {
+	var got []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, password, ok := r.BasicAuth(); !ok || user != "user" || password != "secret" {
+			fmt.Fprint(w, "badauth")
+			return
+		}
+		got = append(got, r.URL.Query().Get("hostname")+" "+r.URL.Query().Get("myip"))
+		fmt.Fprint(w, "good "+r.URL.Query().Get("myip"))
+	}))
+	defer srv.Close()
+
+	raw, _ := json.Marshal(map[string]string{"username": "user", "password": "secret", "url": srv.URL + "/nic/update?hostname=home.example.com"})
+	p, err := newBasicAuthProvider(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Upsert(context.Background(), nil, "A", "192.0.2.1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Upsert(context.Background(), nil, "AAAA", "2001:db8::1"); err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(got, []string{"home.example.com 192.0.2.1", "home.example.com 2001:db8::1"}) {
+		t.Errorf("service got %v, want each address in myip", got)
+	}
+}
+
+func TestBasicAuthName(t *testing.T) {
+	names := make(map[string]string)
+	for _, u := range []string{
+		"https://www.duckdns.org/update?domains=a&token=secret",
+		"https://www.duckdns.org/update?token=secret&domains=b",
+		"https://freedns.afraid.org/dynamic/update.php?token1",
+		"https://freedns.afraid.org/dynamic/update.php?token2",
+		"http://members.3322.net/dyndns/update?system=dyndns&hostname=a.f3322.net",
+		"http://members.3322.net/dyndns/update?system=dyndns&hostname=b.f3322.net",
+	} {
+		raw, _ := json.Marshal(map[string]string{"url": u})
+		p, err := newBasicAuthProvider(raw)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.Contains(p.Name(), "secret") || strings.Contains(p.Name(), "token") {
+			t.Errorf("%s: name %s leaks the URL's secret", u, p.Name())
+		}
+		if other, ok := names[p.Name()]; ok {
+			t.Errorf("%s and %s are both named %s", other, u, p.Name())
+		}
+		names[p.Name()] = u
+	}
+	if _, ok := names["basic:members.3322.net/a.f3322.net"]; !ok {
+		t.Errorf("URLs with a hostname aren't named by it: %v", names)
+	}
+}
+
+func TestBasicAuthSameHost(t *testing.T) {
+	providers, _, err := newProviders(map[string]json.RawMessage{"basic": json.RawMessage(`[
+		{"url": "https://www.duckdns.org/update?domains=a&token=secret"},
+		{"url": "https://www.duckdns.org/update?domains=b&token=secret"}
+	]`)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(providers) != 2 {
+		t.Errorf("loaded %d items, want 2", len(providers))
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"strings"
+)
+
+type bindKey struct{}
+
+// withBind makes the IP sources asked with ctx connect from the local
+// address or through the interface bind, so each uplink of a multi-WAN
+// router reports its own external address. An empty bind changes nothing.
+func withBind(ctx context.Context, bind string) context.Context {
+	if len(bind) == 0 {
+		return ctx
+	}
+	return context.WithValue(ctx, bindKey{}, bind)
+}
+
+// newDialer returns a dialer for network honoring the bind of ctx.
+func newDialer(ctx context.Context, network string) (*net.Dialer, error) {
+	dialer := &net.Dialer{}
+	bind, _ := ctx.Value(bindKey{}).(string)
+	if len(bind) == 0 {
+		return dialer, nil
+	}
+	if addr, err := netip.ParseAddr(bind); err == nil {
+		ip := net.IP(addr.AsSlice())
+		if strings.HasPrefix(network, "udp") {
+			dialer.LocalAddr = &net.UDPAddr{IP: ip}
+		} else {
+			dialer.LocalAddr = &net.TCPAddr{IP: ip}
+		}
+		return dialer, nil
+	}
+	control, err := bindToDevice(bind)
+	if err != nil {
+		return nil, err
+	}
+	dialer.Control = control
+	return dialer, nil
+}
+
+// dialContext is net.Dialer.DialContext honoring the bind of ctx.
+func dialContext(ctx context.Context, network string, address string) (net.Conn, error) {
+	dialer, err := newDialer(ctx, network)
+	if err != nil {
+		return nil, err
+	}
+	return dialer.DialContext(ctx, network, address)
+}
+
+// listenPacket is net.ListenPacket honoring the bind of ctx, address must
+// only give the port then.
+func listenPacket(ctx context.Context, network string, address string) (net.PacketConn, error) {
+	dialer, err := newDialer(ctx, network)
+	if err != nil {
+		return nil, err
+	}
+	if dialer.LocalAddr != nil {
+		_, port, err := net.SplitHostPort(address)
+		if err != nil {
+			return nil, err
+		}
+		address = net.JoinHostPort(dialer.LocalAddr.(*net.UDPAddr).IP.String(), port)
+	}
+	lc := net.ListenConfig{Control: dialer.Control}
+	return lc.ListenPacket(ctx, network, address)
+}
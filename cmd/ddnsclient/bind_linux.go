@@ -0,0 +1,17 @@
+package main
+
+import "syscall"
+
+// bindToDevice returns a socket control function binding to the interface
+// by SO_BINDTODEVICE, which needs CAP_NET_RAW on kernels before 5.7.
+func bindToDevice(name string) (func(network, address string, c syscall.RawConn) error, error) {
+	return func(network, address string, c syscall.RawConn) error {
+		var err error
+		if cerr := c.Control(func(fd uintptr) {
+			err = syscall.SetsockoptString(int(fd), syscall.SOL_SOCKET, syscall.SO_BINDTODEVICE, name)
+		}); cerr != nil {
+			return cerr
+		}
+		return err
+	}, nil
+}
@@ -0,0 +1,13 @@
+//go:build !linux
+
+package main
+
+import (
+	"errors"
+	"syscall"
+)
+
+// bindToDevice isn't implemented on this platform, bind to the address of the interface instead.
+func bindToDevice(name string) (func(network, address string, c syscall.RawConn) error, error) {
+	return nil, errors.New("binding to interface " + name + " is only supported on Linux, bind to its address instead")
+}
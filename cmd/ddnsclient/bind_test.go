@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"syscall"
+	"testing"
+
+	"github.com/missdeer/ddnsclient/models"
+)
+
+func TestBindAddress(t *testing.T) {
+	// echo the client address like an ifconfig service
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host, _, _ := net.SplitHostPort(r.RemoteAddr)
+		w.Write([]byte(host))
+	}))
+	defer server.Close()
+
+	ip, err := fetchExternalIP(withBind(context.Background(), "127.0.0.1"), server.URL, true)
+	if err != nil {
+		t.Fatal("binding to 127.0.0.1:", err)
+	}
+	if ip != "127.0.0.1" {
+		t.Errorf("bound to 127.0.0.1: got %s", ip)
+	}
+
+	// binding to an address the host doesn't own fails
+	if _, err := fetchExternalIP(withBind(context.Background(), "192.0.2.1"), server.URL, true); err == nil {
+		t.Error("connected from an address that isn't local")
+	}
+}
+
+func TestBindDevice(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("binding to an interface is only supported on Linux")
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host, _, _ := net.SplitHostPort(r.RemoteAddr)
+		w.Write([]byte(host))
+	}))
+	defer server.Close()
+
+	ip, err := fetchExternalIP(withBind(context.Background(), "lo"), server.URL, true)
+	if errors.Is(err, syscall.EPERM) {
+		t.Skip("SO_BINDTODEVICE needs CAP_NET_RAW:", err)
+	}
+	if err != nil {
+		t.Fatal("binding to lo:", err)
+	}
+	if ip != "127.0.0.1" {
+		t.Errorf("bound to lo: got %s", ip)
+	}
+}
+
+func TestAddressKey(t *testing.T) {
+	wan1 := &models.RecordOptions{Stack: "ipv4", Bind: "eth1"}
+	wan2 := &models.RecordOptions{Stack: "ipv4", Bind: "192.0.2.10"}
+	defaultRoute := &models.RecordOptions{Stack: "ipv4"}
+	keys := map[string]bool{}
+	for _, o := range []*models.RecordOptions{wan1, wan2, defaultRoute} {
+		keys[addressKey(o, true)] = true
+	}
+	if len(keys) != 3 {
+		t.Fatalf("records bound to different uplinks share addresses: %v", keys)
+	}
+
+	clear(currentIPs)
+	defer clear(currentIPs)
+	currentIPs[addressKey(wan1, true)] = "198.51.100.1"
+	currentIPs[addressKey(wan2, true)] = "203.0.113.2"
+	if got := recordValues(wan1)["A"]; got != "198.51.100.1" {
+		t.Errorf("wan1 got %s", got)
+	}
+	if got := recordValues(wan2)["A"]; got != "203.0.113.2" {
+		t.Errorf("wan2 got %s", got)
+	}
+	if got, ok := recordValues(defaultRoute)["A"]; ok {
+		t.Errorf("record without bind got %s", got)
+	}
+}
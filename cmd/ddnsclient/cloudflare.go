@@ -2,83 +2,165 @@ package main
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
 	"time"
 
 	"github.com/cloudflare/cloudflare-go"
+	"github.com/missdeer/ddnsclient/models"
 )
 
-func cloudflareRequest(user string, token string, domain string, subDomain string, isInternal bool) error {
-	// Construct a new API object
-	api, err := cloudflare.New(token, user)
-	if err != nil {
-		log.Fatal(err)
-		return err
+func init() {
+	registerProvider("cloudflare", newCloudflareProvider)
+}
+
+// cloudflareProvider manages a record through the Cloudflare API with the
+// account email and global API key.
+type cloudflareProvider struct {
+	item   models.CloudflareConfigurationItem
+	api    *cloudflare.API
+	zoneId string
+}
+
+func newCloudflareProvider(raw json.RawMessage) (Provider, error) {
+	p := &cloudflareProvider{}
+	if err := json.Unmarshal(raw, &p.item); err != nil {
+		return nil, err
 	}
+	return p, nil
+}
+
+func (p *cloudflareProvider) Name() string {
+	return "cloudflare:" + p.recordName()
+}
+
+func (p *cloudflareProvider) Options() *models.RecordOptions {
+	return &p.item.RecordOptions
+}
 
-	ctx := context.Background()
-	// Fetch user details on the account
-	u, err := api.UserDetails(ctx)
+func (p *cloudflareProvider) Validate() error {
+	if len(p.item.Domain) == 0 {
+		return errors.New("missing domain")
+	}
+	if len(p.item.UserName) == 0 || len(p.item.Token) == 0 {
+		return errors.New("missing username or token")
+	}
+	return nil
+}
+
+func (p *cloudflareProvider) CurrentRecord(ctx context.Context, recordType string) (*Record, error) {
+	zone, err := p.zone(ctx)
 	if err != nil {
-		log.Fatal(err)
-		return err
+		return nil, err
 	}
-	// Print user details
-	fmt.Println("Cloudflare user information:", u)
 
-	// Fetch the zone ID
-	id, err := api.ZoneIDByName(domain) // Assuming example.com exists in your Cloudflare account already
+	// Fetch the matching records of the zone
+	recs, _, err := p.api.ListDNSRecords(ctx, zone, cloudflare.ListDNSRecordsParams{Type: recordType, Name: p.recordName()})
 	if err != nil {
-		log.Fatal(err)
-		return err
+		fmt.Println(err)
+		return nil, cloudflareError(err)
 	}
+	if len(recs) == 0 {
+		return nil, nil
+	}
+	return &Record{ID: recs[0].ID, Type: recs[0].Type, Value: recs[0].Content}, nil
+}
 
-	// Fetch zone details
-	zone, err := api.ZoneDetails(ctx, id)
+func (p *cloudflareProvider) Upsert(ctx context.Context, record *Record, recordType string, value string) error {
+	zone, err := p.zone(ctx)
 	if err != nil {
-		log.Fatal(err)
 		return err
 	}
-	// Print zone details
-	fmt.Println("Cloudflare zone detail:", zone)
+	if record == nil {
+		// insert a new record
+		_, err = p.api.CreateDNSRecord(ctx, zone, cloudflare.CreateDNSRecordParams{
+			Type:    recordType,
+			Name:    p.recordName(),
+			Content: value,
+		})
+		if err != nil {
+			fmt.Println(err)
+			return cloudflareError(err)
+		}
+		fmt.Printf("[%v] %s record created to cloudflare: %s => %s\n", time.Now(), recordType, p.recordName(), value)
+		return nil
+	}
 
-	// Fetch all records for a zone
-	recs, err := api.DNSRecords(ctx, id, cloudflare.DNSRecord{Type: "A", Name: subDomain + "." + domain})
+	// update
+	_, err = p.api.UpdateDNSRecord(ctx, zone, cloudflare.UpdateDNSRecordParams{
+		ID:      record.ID,
+		Type:    recordType,
+		Name:    p.recordName(),
+		Content: value,
+	})
 	if err != nil {
-		log.Fatal(err)
-		return err
+		fmt.Println(err)
+		return cloudflareError(err)
 	}
+	fmt.Printf("[%v] %s record updated to cloudflare: %s => %s\n", time.Now(), recordType, p.recordName(), value)
+	return nil
+}
 
-	newIP := currentExternalIPv4
-	if isInternal {
-		newIP = currentInternalIPv4
+func (p *cloudflareProvider) Delete(ctx context.Context, recordType string) error {
+	record, err := p.CurrentRecord(ctx, recordType)
+	if err != nil || record == nil {
+		return err
 	}
-	r := cloudflare.DNSRecord{
-		Type:    "A",
-		Name:    subDomain + "." + domain,
-		Content: newIP,
-		ZoneID:  id,
+	if err = p.api.DeleteDNSRecord(ctx, cloudflare.ZoneIdentifier(p.zoneId), record.ID); err != nil {
+		fmt.Println(err)
+		return cloudflareError(err)
 	}
-	if len(recs) == 0 {
-		// insert a new record
-		_, err = api.CreateDNSRecord(ctx, id, r)
+	fmt.Printf("[%v] %s record removed from cloudflare: %s\n", time.Now(), recordType, p.recordName())
+	return nil
+}
+
+// zone constructs the API object and looks up the zone ID on first use.
+func (p *cloudflareProvider) zone(ctx context.Context) (*cloudflare.ResourceContainer, error) {
+	if p.api == nil {
+		// retries are left to the retry policy of the scheduler
+		api, err := cloudflare.New(p.item.Token, p.item.UserName, cloudflare.UsingRetryPolicy(0, 1, 1))
 		if err != nil {
 			fmt.Println(err)
-			return err
-		} else {
-			fmt.Printf("[%v] A record created to cloudflare: %s.%s => %s\n", time.Now(), subDomain, domain, newIP)
+			return nil, err
 		}
-	} else {
-		// update
-		err = api.UpdateDNSRecord(ctx, id, recs[0].ID, r)
+		p.api = api
+	}
+	if len(p.zoneId) == 0 {
+		zones, err := p.api.ListZonesContext(ctx, cloudflare.WithZoneFilters(p.item.Domain, "", ""))
 		if err != nil {
 			fmt.Println(err)
-			return err
-		} else {
-			fmt.Printf("[%v] A record updated to cloudflare: %s.%s => %s\n", time.Now(), subDomain, domain, newIP)
+			return nil, cloudflareError(err)
+		}
+		if len(zones.Result) == 0 {
+			fmt.Printf("zone %s doesn't exists\n", p.item.Domain)
+			return nil, notFoundError(errors.New("zone could not be found"))
 		}
+		p.zoneId = zones.Result[0].ID
 	}
+	return cloudflare.ZoneIdentifier(p.zoneId), nil
+}
 
-	return nil
+// cloudflareError classifies the typed errors of cloudflare-go.
+func cloudflareError(err error) error {
+	var (
+		authenticationErr *cloudflare.AuthenticationError
+		authorizationErr  *cloudflare.AuthorizationError
+		notFoundErr       *cloudflare.NotFoundError
+		requestErr        *cloudflare.RequestError
+	)
+	switch {
+	case errors.As(err, &authenticationErr), errors.As(err, &authorizationErr):
+		return authError(err)
+	case errors.As(err, &notFoundErr):
+		return notFoundError(err)
+	case errors.As(err, &requestErr):
+		return validationError(err)
+	}
+	// rate limit, service and network errors
+	return err
+}
+
+func (p *cloudflareProvider) recordName() string {
+	return fullDomain(p.item.SubDomain, p.item.Domain)
 }
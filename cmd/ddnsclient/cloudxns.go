@@ -2,6 +2,7 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"crypto/md5"
 	"encoding/hex"
 	"encoding/json"
@@ -14,191 +15,200 @@ import (
 	"github.com/missdeer/ddnsclient/models"
 )
 
-func cloudxnsFindDomain(apiKey string, secretKey string, domain string) int {
-	client := &http.Client{}
-	// get domain list
-	cloudxnsAPIUrl := "https://www.cloudxns.net/api2/domain"
-	req, err := http.NewRequest("GET", cloudxnsAPIUrl, nil)
-	req.Header.Set("API-KEY", apiKey)
-	apiRequestDate := time.Now().String()
-	req.Header.Add("API-REQUEST-DATE", apiRequestDate)
-	sum := md5.Sum([]byte(apiKey + cloudxnsAPIUrl + apiRequestDate + secretKey))
-	req.Header.Add("API-HMAC", hex.EncodeToString(sum[:]))
-	resp, err := client.Do(req)
-	if err != nil {
-		fmt.Println("Getting CloudXNS domain list failed", err)
-		return -1
+// cloudxnsDefaultLineId is the "全网默认" resolve line.
+const cloudxnsDefaultLineId = 1
+
+func init() {
+	registerProvider("cloudxns", newCloudXNSProvider)
+}
+
+// cloudxnsProvider manages a record through the CloudXNS API v2.
+type cloudxnsProvider struct {
+	item     models.CloudXNSConfigurationItem
+	domainId int
+}
+
+func newCloudXNSProvider(raw json.RawMessage) (Provider, error) {
+	p := &cloudxnsProvider{domainId: -1}
+	if err := json.Unmarshal(raw, &p.item); err != nil {
+		return nil, err
 	}
-	defer resp.Body.Close()
+	return p, nil
+}
 
-	body, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		fmt.Printf("reading cloudflare all domain list failed\n")
-		return -1
+func (p *cloudxnsProvider) Name() string {
+	return "cloudxns:" + fullDomain(p.item.SubDomain, p.item.Domain)
+}
+
+func (p *cloudxnsProvider) Options() *models.RecordOptions {
+	return &p.item.RecordOptions
+}
+
+func (p *cloudxnsProvider) Validate() error {
+	if len(p.item.Domain) == 0 {
+		return errors.New("missing domain")
+	}
+	if len(p.item.APIKey) == 0 || len(p.item.SecretKey) == 0 {
+		return errors.New("missing apikey or secretkey")
 	}
+	return nil
+}
 
-	recordList := new(models.CloudXNSDomainList)
-	if err = json.Unmarshal(body, &recordList); err != nil {
-		fmt.Printf("unmarshalling CloudXNS all domain list %s failed: %v\n", string(body), err)
-		return -1
+func (p *cloudxnsProvider) CurrentRecord(ctx context.Context, recordType string) (*Record, error) {
+	// find the domain
+	domainId, err := p.findDomain(ctx)
+	if err != nil {
+		return nil, err
+	}
+	// find the host, a missing host simply has no records yet
+	hostRecordId, err := p.findHostRecord(ctx, domainId)
+	if err != nil || hostRecordId == -1 {
+		return nil, err
 	}
 
-	docoratedDomain := domain + "."
+	// get resolve record list
+	recordList := new(models.CloudXNSResolveList)
+	err = p.request(ctx, "GET", fmt.Sprintf("https://www.cloudxns.net/api2/record/%d?host_id=%d&offset=0&row_num=2000", domainId, hostRecordId), nil, recordList)
+	if err != nil {
+		fmt.Println("Getting CloudXNS resolve record list failed", err)
+		return nil, err
+	}
 	for _, v := range recordList.Data {
-		if v.Domain == docoratedDomain {
-			return v.Id
+		if v.Type == recordType {
+			return &Record{ID: fmt.Sprintf("%d", v.RecordId), Type: v.Type, Value: v.Value}, nil
 		}
 	}
-	return -1
+	return nil, nil
 }
 
-func cloudxnsFindHostRecord(apiKey string, secretKey string, domainId int, subDomain string) int {
-	client := &http.Client{}
-	// get host record list
-	cloudxnsAPIUrl := fmt.Sprintf("https://www.cloudxns.net/api2/host/%d?offset=0&row_num=2000", domainId)
-	req, err := http.NewRequest("GET", cloudxnsAPIUrl, nil)
-	req.Header.Set("API-KEY", apiKey)
-	apiRequestDate := time.Now().String()
-	req.Header.Add("API-REQUEST-DATE", apiRequestDate)
-	sum := md5.Sum([]byte(apiKey + cloudxnsAPIUrl + apiRequestDate + secretKey))
-	req.Header.Add("API-HMAC", hex.EncodeToString(sum[:]))
-	resp, err := client.Do(req)
+func (p *cloudxnsProvider) Upsert(ctx context.Context, record *Record, recordType string, value string) error {
+	domainId, err := p.findDomain(ctx)
 	if err != nil {
-		fmt.Println("Getting CloudXNS host record list failed", err)
-		return -1
+		return err
+	}
+
+	postValues := make(map[string]interface{})
+	postValues["host"] = p.item.SubDomain
+	postValues["value"] = value
+	if record != nil {
+		// update
+		postValues["domain_id"] = domainId
+		postValues["type"] = recordType
+		body, err := json.Marshal(postValues)
+		if err != nil {
+			fmt.Println("marshal update body failed", err)
+			return err
+		}
+		if err = p.request(ctx, "PUT", "https://www.cloudxns.net/api2/record/"+record.ID, body, nil); err != nil {
+			fmt.Printf("[%v] Updating CloudXNS resolve item failed: %v\n", time.Now(), err)
+			return err
+		}
+		fmt.Printf("%s record updated to cloudXNS: %s.%s => %s\n", recordType, p.item.SubDomain, p.item.Domain, value)
+		return nil
 	}
-	defer resp.Body.Close()
 
-	body, err := ioutil.ReadAll(resp.Body)
+	// insert
+	postValues["domain_id"] = fmt.Sprintf("%d", domainId)
+	postValues["type"] = recordType
+	postValues["line_id"] = fmt.Sprintf("%d", cloudxnsDefaultLineId)
+	body, err := json.Marshal(postValues)
 	if err != nil {
-		fmt.Printf("reading CloudXNS all host records failed\n")
-		return -1
+		fmt.Println("marshal insert body failed", err)
+		return err
+	}
+	if err = p.request(ctx, "POST", "https://www.cloudxns.net/api2/record", body, nil); err != nil {
+		fmt.Printf("[%v] inserting CloudXNS resolve item failed: %v\n", time.Now(), err)
+		return err
+	}
+	fmt.Printf("%s record inserted to cloudXNS: %s.%s => %s\n", recordType, p.item.SubDomain, p.item.Domain, value)
+	return nil
+}
+
+func (p *cloudxnsProvider) Delete(ctx context.Context, recordType string) error {
+	record, err := p.CurrentRecord(ctx, recordType)
+	if err != nil || record == nil {
+		return err
+	}
+	if err = p.request(ctx, "DELETE", fmt.Sprintf("https://www.cloudxns.net/api2/record/%s/%d", record.ID, p.domainId), nil, nil); err != nil {
+		fmt.Printf("[%v] removing CloudXNS resolve item failed: %v\n", time.Now(), err)
+		return err
 	}
+	fmt.Printf("%s record removed from cloudXNS: %s.%s\n", recordType, p.item.SubDomain, p.item.Domain)
+	return nil
+}
 
+func (p *cloudxnsProvider) findDomain(ctx context.Context) (int, error) {
+	if p.domainId != -1 {
+		return p.domainId, nil
+	}
+	// get domain list
+	domainList := new(models.CloudXNSDomainList)
+	if err := p.request(ctx, "GET", "https://www.cloudxns.net/api2/domain", nil, domainList); err != nil {
+		fmt.Println("Getting CloudXNS domain list failed", err)
+		return -1, err
+	}
+
+	docoratedDomain := p.item.Domain + "."
+	for _, v := range domainList.Data {
+		if v.Domain == docoratedDomain {
+			p.domainId = v.Id
+			return v.Id, nil
+		}
+	}
+	fmt.Println("can't find domain in list", p.item.Domain)
+	return -1, notFoundError(errors.New("domain not exists"))
+}
+
+func (p *cloudxnsProvider) findHostRecord(ctx context.Context, domainId int) (int, error) {
+	// get host record list
 	recordList := new(models.CloudXNSHostRecordList)
-	if err = json.Unmarshal(body, &recordList); err != nil {
-		fmt.Printf("unmarshalling CloudXNS all records %s failed\n", string(body))
-		return -1
+	err := p.request(ctx, "GET", fmt.Sprintf("https://www.cloudxns.net/api2/host/%d?offset=0&row_num=2000", domainId), nil, recordList)
+	if err != nil {
+		fmt.Println("Getting CloudXNS host record list failed", err)
+		return -1, err
 	}
 
 	for _, v := range recordList.Data {
-		if v.Host == subDomain {
-			return v.Id
+		if v.Host == p.item.SubDomain {
+			return v.Id, nil
 		}
 	}
-
-	return -1
+	return -1, nil
 }
 
-func cloudxnsRequest(apiKey string, secretKey string, domain string, subDomain string, isInternal bool) error {
-	// find the domain
-	domainId := cloudxnsFindDomain(apiKey, secretKey, domain)
-	if domainId == -1 {
-		fmt.Println("can't find domain in list", domain)
-		return errors.New("domain not exists")
-	}
-	// find the host
-	hostRecordId := cloudxnsFindHostRecord(apiKey, secretKey, domainId, subDomain)
-	if hostRecordId == -1 {
-		fmt.Println("can't find host record in list", subDomain)
-		return errors.New("host record not exists")
-	}
-	// find the resolve record
-	client := &http.Client{}
-
-	// get resolve record list
-	cloudxnsAPIUrl := fmt.Sprintf("https://www.cloudxns.net/api2/record/%d?host_id=%d&offset=0&row_num=2000", domainId, hostRecordId)
-	req, err := http.NewRequest("GET", cloudxnsAPIUrl, nil)
-	req.Header.Set("API-KEY", apiKey)
+// request sends a signed API request and unmarshals the JSON response into v if it's not nil.
+func (p *cloudxnsProvider) request(ctx context.Context, method string, apiURL string, body []byte, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, method, apiURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("API-KEY", p.item.APIKey)
 	apiRequestDate := time.Now().String()
 	req.Header.Add("API-REQUEST-DATE", apiRequestDate)
-	sum := md5.Sum([]byte(apiKey + cloudxnsAPIUrl + apiRequestDate + secretKey))
+	sum := md5.Sum([]byte(p.item.APIKey + apiURL + string(body) + apiRequestDate + p.item.SecretKey))
 	req.Header.Add("API-HMAC", hex.EncodeToString(sum[:]))
+
+	client := &http.Client{}
 	resp, err := client.Do(req)
 	if err != nil {
-		fmt.Println("Getting CloudXNS resolve record list failed", err)
 		return err
 	}
 	defer resp.Body.Close()
-
-	body, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		fmt.Printf("reading cloudflare all resolve records failed\n")
+	if err = checkResponse(resp); err != nil {
 		return err
 	}
 
-	recordList := new(models.CloudXNSResolveList)
-	if err = json.Unmarshal(body, &recordList); err != nil {
-		fmt.Printf("unmarshalling CloudXNS all resolve records %s failed: %v\n", string(body), err)
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		fmt.Printf("reading CloudXNS response of %s failed\n", apiURL)
 		return err
 	}
-
-	// insert or update
-	foundRecord := false
-	var recordId int
-	var lineId int
-	if len(recordList.Data) > 0 {
-		foundRecord = true
-		recordId = recordList.Data[0].RecordId
-		lineId = recordList.Data[0].LineId
-	}
-
-	newIP := currentExternalIPv4
-	if isInternal {
-		newIP = currentInternalIPv4
+	if v == nil {
+		return nil
 	}
-	postValues := make(map[string]interface{})
-	if foundRecord {
-		// update
-		postValues["domain_id"] = domainId
-		postValues["host"] = subDomain
-		postValues["value"] = newIP
-		p, err := json.Marshal(postValues)
-		if err != nil {
-			fmt.Println("marshal update body failed", err)
-			return err
-		}
-
-		cloudxnsAPIUrl := fmt.Sprintf("https://www.cloudxns.net/api2/record/%d", recordId)
-		req, err := http.NewRequest("PUT", cloudxnsAPIUrl, bytes.NewReader(p))
-		req.Header.Set("API-KEY", apiKey)
-		apiRequestDate := time.Now().String()
-		req.Header.Add("API-REQUEST-DATE", apiRequestDate)
-		sum := md5.Sum([]byte(apiKey + cloudxnsAPIUrl + string(p) + apiRequestDate + secretKey))
-		req.Header.Add("API-HMAC", hex.EncodeToString(sum[:]))
-		resp, err := client.Do(req)
-		if err != nil {
-			fmt.Printf("[%v] Updating CloudXNS resolve item failed: %v", time.Now(), err)
-			return err
-		}
-		defer resp.Body.Close()
-		fmt.Printf("A record updated to cloudXNS: %s.%s => %s\n", subDomain, domain, currentExternalIPv4)
-	} else {
-		// insert
-		postValues["domain_id"] = fmt.Sprintf("%d", domainId)
-		postValues["host"] = subDomain
-		postValues["value"] = newIP
-		postValues["type"] = "A"
-		postValues["line_id"] = fmt.Sprintf("%d", lineId)
-		p, err := json.Marshal(postValues)
-		if err != nil {
-			fmt.Println("marshal update body failed", err)
-			return err
-		}
-		cloudxnsAPIUrl := "https://www.cloudxns.net/api2/record"
-		req, err := http.NewRequest("POST", cloudxnsAPIUrl, bytes.NewReader(p))
-		req.Header.Set("API-KEY", apiKey)
-		apiRequestDate := time.Now().String()
-		req.Header.Add("API-REQUEST-DATE", apiRequestDate)
-		sum := md5.Sum([]byte(apiKey + cloudxnsAPIUrl + string(p) + apiRequestDate + secretKey))
-		req.Header.Add("API-HMAC", hex.EncodeToString(sum[:]))
-		resp, err := client.Do(req)
-		if err != nil {
-			fmt.Printf("[%v] inserting CloudXNS resolve item failed: %v", time.Now(), err)
-			return err
-		}
-		defer resp.Body.Close()
-		fmt.Printf("A record inserted to cloudXNS: %s.%s => %s\n", subDomain, domain, newIP)
+	if err = json.Unmarshal(respBody, v); err != nil {
+		fmt.Printf("unmarshalling CloudXNS response %s failed: %v\n", string(respBody), err)
+		return err
 	}
 	return nil
 }
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/missdeer/ddnsclient/models"
+)
+
+// flaps holds back addresses of records which change too often.
+var flaps = newFlapDamper()
+
+// observation is a new address seen for a record since a point in time.
+type observation struct {
+	value  string
+	since  time.Time
+	checks int
+}
+
+// flapDamper keeps the address of a record until a new one has been stable
+// for the number of checks and the duration of the record options, and not
+// written again before the minimum interval has passed since the last write.
+// Each updateDDNS cycle is one check.
+type flapDamper struct {
+	mu      sync.Mutex
+	pending map[string]*observation
+}
+
+func newFlapDamper() *flapDamper {
+	return &flapDamper{pending: make(map[string]*observation)}
+}
+
+// dampingOptions returns the parsed damping options of o, they are checked by validateOptions.
+func dampingOptions(o *models.RecordOptions) (checks int, stableFor time.Duration, minInterval time.Duration) {
+	checks = max(o.StableChecks, 1)
+	if len(o.StableFor) != 0 {
+		stableFor, _ = time.ParseDuration(o.StableFor)
+	}
+	if len(o.MinInterval) != 0 {
+		minInterval, _ = time.ParseDuration(o.MinInterval)
+	}
+	return
+}
+
+// filter drops the values of p that changed from the published ones but
+// aren't stable yet, or that would be written too soon after the last write.
+// Records which were never published aren't held back.
+func (d *flapDamper) filter(p Provider, values map[string]string, state *stateStore, now time.Time) map[string]string {
+	checks, stableFor, minInterval := dampingOptions(p.Options())
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for recordType, value := range values {
+		key := recordKey(p, recordType)
+		st, ok := state.get(key)
+		if !ok || len(st.Value) == 0 || st.Value == value {
+			delete(d.pending, key)
+			continue
+		}
+		o := d.pending[key]
+		if o == nil || o.value != value {
+			if o != nil {
+				log.Printf("%s record of %s flapped from %s to %s, published value %s kept\n", recordType, p.Name(), o.value, value, st.Value)
+			}
+			o = &observation{value: value, since: now}
+			d.pending[key] = o
+		}
+		o.checks++
+		switch {
+		case o.checks < checks || now.Sub(o.since) < stableFor:
+			log.Printf("%s record of %s held at %s: %s seen %d of %d checks for %v of %v\n",
+				recordType, p.Name(), st.Value, value, o.checks, checks, now.Sub(o.since).Round(time.Second), stableFor)
+		case now.Sub(st.UpdatedAt) < minInterval:
+			log.Printf("%s record of %s held at %s: %s has to wait until %v, %v after the last write\n",
+				recordType, p.Name(), st.Value, value, st.UpdatedAt.Add(minInterval).Format(time.DateTime), minInterval)
+		default:
+			delete(d.pending, key)
+			continue
+		}
+		delete(values, recordType)
+	}
+	return values
+}
+
+// holding reports whether any change is held back, the next check has to
+// look at it again even if no address changed.
+func (d *flapDamper) holding() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return len(d.pending) != 0
+}
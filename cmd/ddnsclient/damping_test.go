@@ -0,0 +1,63 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/missdeer/ddnsclient/models"
+)
+
+func TestFlapDamper(t *testing.T) {
+	state, _ := loadState("")
+	p := &fakeProvider{options: models.RecordOptions{StableChecks: 3, StableFor: "2m", MinInterval: "10m"}}
+	key := recordKey(p, "A")
+	start := time.Now()
+	state.set(key, recordState{Value: "1.1.1.1", UpdatedAt: start.Add(-5 * time.Minute), Result: resultGood})
+
+	d := newFlapDamper()
+	check := func(value string, at time.Duration) bool {
+		values := d.filter(p, map[string]string{"A": value}, state, start.Add(at))
+		return len(values) != 0
+	}
+	// bouncing between two addresses never gets published
+	for i, value := range []string{"2.2.2.2", "2.2.2.2", "3.3.3.3", "2.2.2.2", "3.3.3.3"} {
+		if check(value, time.Duration(i)*time.Minute) {
+			t.Fatalf("check %d: %s published while flapping", i, value)
+		}
+	}
+	if !d.holding() {
+		t.Fatal("flapping change not held")
+	}
+	// stable for 3 checks but only 1 minute
+	if check("3.3.3.3", 5*time.Minute) || check("3.3.3.3", 5*time.Minute+30*time.Second) {
+		t.Fatal("published before being stable for 2 minutes")
+	}
+	// stable long enough, but only 9 minutes after the last write
+	state.set(key, recordState{Value: "1.1.1.1", UpdatedAt: start, Result: resultGood})
+	if check("3.3.3.3", 9*time.Minute) {
+		t.Fatal("published before the minimum interval")
+	}
+	if !check("3.3.3.3", 10*time.Minute) {
+		t.Fatal("stable address not published")
+	}
+	if d.holding() {
+		t.Fatal("published change still held")
+	}
+	// going back to the published value drops the pending change
+	check("4.4.4.4", 11*time.Minute)
+	if !check("1.1.1.1", 12*time.Minute) {
+		t.Fatal("published value held back")
+	}
+	if d.holding() {
+		t.Fatal("change held after returning to the published value")
+	}
+}
+
+func TestFlapDamperUnpublished(t *testing.T) {
+	state, _ := loadState("")
+	p := &fakeProvider{options: models.RecordOptions{StableChecks: 5}}
+	values := newFlapDamper().filter(p, map[string]string{"A": "2.2.2.2"}, state, time.Now())
+	if values["A"] != "2.2.2.2" {
+		t.Fatal("never published record held back")
+	}
+}
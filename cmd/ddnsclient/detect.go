@@ -0,0 +1,270 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/netip"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/missdeer/ddnsclient/models"
+)
+
+// ipSource detects the external address of the host.
+type ipSource interface {
+	// Name identifies the source in logs.
+	Name() string
+	// Detect returns the external IPv4 or IPv6 address.
+	Detect(ctx context.Context, ipv4 bool) (netip.Addr, error)
+}
+
+// ipSourceFactory builds a source from its spec, e.g. "https://ifconfig.minidump.info".
+type ipSourceFactory func(spec string) (ipSource, error)
+
+var ipSourceFactories = make(map[string]ipSourceFactory)
+
+// registerIPSource makes a source available for specs starting with scheme followed by a colon.
+func registerIPSource(scheme string, factory ipSourceFactory) {
+	if _, ok := ipSourceFactories[scheme]; ok {
+		panic("IP source registered twice: " + scheme)
+	}
+	ipSourceFactories[scheme] = factory
+}
+
+func newIPSource(spec string) (ipSource, error) {
+	scheme, _, ok := strings.Cut(spec, ":")
+	if !ok {
+		return nil, fmt.Errorf("invalid IP source %q", spec)
+	}
+	factory, ok := ipSourceFactories[strings.ToLower(scheme)]
+	if !ok {
+		return nil, fmt.Errorf("unknown IP source type %q", scheme)
+	}
+	return factory(spec)
+}
+
+func init() {
+	newHTTPSource := func(spec string) (ipSource, error) {
+		u, err := url.Parse(spec)
+		if err != nil {
+			return nil, err
+		}
+		if _, err = newResponseExtractor(u.Fragment); err != nil {
+			return nil, err
+		}
+		return &httpSource{url: spec}, nil
+	}
+	registerIPSource("http", newHTTPSource)
+	registerIPSource("https", newHTTPSource)
+}
+
+// httpSource asks an ifconfig service which echoes the client address, the
+// URL fragment tells how to find it in the response, see newResponseExtractor.
+type httpSource struct {
+	url string
+}
+
+func (s *httpSource) Name() string {
+	return s.url
+}
+
+func (s *httpSource) Detect(ctx context.Context, ipv4 bool) (netip.Addr, error) {
+	ip, err := fetchExternalIP(ctx, s.url, ipv4)
+	if err != nil {
+		return netip.Addr{}, err
+	}
+	return netip.ParseAddr(ip)
+}
+
+// maxSourceHealth is the health of a source that recently agreed with the
+// consensus, a source whose health drops to 0 is demoted.
+const maxSourceHealth = 3
+
+// detector asks all its sources concurrently and returns the address at
+// least quorum healthy sources agree on. Each source earns health by agreeing
+// with the consensus and loses it by failing or disagreeing. Demoted sources
+// are still asked so they can recover, but their votes only count when the
+// healthy sources alone can't reach the quorum.
+type detector struct {
+	sources []ipSource
+	quorum  int
+
+	mu     sync.Mutex
+	health map[string]int
+}
+
+func newDetector(sources []ipSource, quorum int) *detector {
+	if quorum < 1 {
+		quorum = 1
+	}
+	if quorum > len(sources) {
+		quorum = len(sources)
+	}
+	health := make(map[string]int, len(sources))
+	for _, s := range sources {
+		health[s.Name()] = maxSourceHealth
+	}
+	return &detector{sources: sources, quorum: quorum, health: health}
+}
+
+// splitSpecs splits a comma separated list of source specs. Only commas
+// followed by a known "scheme:" separate specs, so a spec can contain commas
+// too, e.g. in a "#regex=" fragment like "\d{1,3}".
+func splitSpecs(specs string) []string {
+	var split []string
+	for _, part := range strings.Split(specs, ",") {
+		if len(strings.TrimSpace(part)) == 0 {
+			continue
+		}
+		scheme, _, ok := strings.Cut(strings.TrimSpace(part), ":")
+		_, known := ipSourceFactories[strings.ToLower(scheme)]
+		if len(split) != 0 && (!ok || !known) {
+			split[len(split)-1] += "," + part
+			continue
+		}
+		split = append(split, part)
+	}
+	return split
+}
+
+// newDetectorFromSpecs builds a detector from a comma separated list of source specs.
+func newDetectorFromSpecs(specs string, quorum int) (*detector, error) {
+	var sources []ipSource
+	for _, spec := range splitSpecs(specs) {
+		spec = strings.TrimSpace(spec)
+		if len(spec) == 0 {
+			continue
+		}
+		s, err := newIPSource(spec)
+		if err != nil {
+			return nil, err
+		}
+		sources = append(sources, s)
+	}
+	if len(sources) == 0 {
+		return nil, errors.New("no IP source")
+	}
+	return newDetector(sources, quorum), nil
+}
+
+// detectorSet keeps a detector per distinct list of sources, so records
+// sharing their sources share the detection and the health of the sources.
+type detectorSet struct {
+	fallback  *detector
+	quorum    int
+	detectors map[string]*detector
+}
+
+// newDetectorSet returns a set giving records without sources fallback,
+// quorum is the default for records with sources.
+func newDetectorSet(fallback *detector, quorum int) *detectorSet {
+	return &detectorSet{fallback: fallback, quorum: quorum, detectors: make(map[string]*detector)}
+}
+
+// get returns the detector for the sources of o.
+func (s *detectorSet) get(o *models.RecordOptions) (*detector, error) {
+	if len(o.Sources) == 0 {
+		return s.fallback, nil
+	}
+	key := fmt.Sprintf("%s/%d", o.Sources, o.Quorum)
+	if d, ok := s.detectors[key]; ok {
+		return d, nil
+	}
+	quorum := o.Quorum
+	if quorum == 0 {
+		quorum = s.quorum
+	}
+	d, err := newDetectorFromSpecs(o.Sources, quorum)
+	if err != nil {
+		return nil, err
+	}
+	s.detectors[key] = d
+	return d, nil
+}
+
+type vote struct {
+	source ipSource
+	addr   netip.Addr
+	err    error
+}
+
+func (d *detector) detect(ctx context.Context, ipv4 bool) (string, error) {
+	votes := make([]vote, len(d.sources))
+	var wg sync.WaitGroup
+	for i, s := range d.sources {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			addr, err := s.Detect(ctx, ipv4)
+			addr = addr.Unmap()
+			if err == nil && addr.Is4() != ipv4 {
+				err = fmt.Errorf("got %s for %s", addr, familyName(ipv4))
+			}
+			votes[i] = vote{source: s, addr: addr, err: err}
+		}()
+	}
+	wg.Wait()
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	winner, count := d.tally(votes, true)
+	if count < d.quorum {
+		winner, count = d.tally(votes, false)
+	}
+	for _, v := range votes {
+		switch {
+		case v.err != nil:
+			log.Printf("IP source %s failed: %v\n", v.source.Name(), v.err)
+			d.adjust(v.source, -1)
+		case count >= d.quorum && v.addr == winner:
+			d.adjust(v.source, 1)
+		case count >= d.quorum:
+			log.Printf("IP source %s disagrees: %s instead of %s\n", v.source.Name(), v.addr, winner)
+			d.adjust(v.source, -1)
+		}
+	}
+	if count < d.quorum {
+		return "", fmt.Errorf("no %s address reported by %d of %d sources", familyName(ipv4), d.quorum, len(d.sources))
+	}
+	return winner.String(), nil
+}
+
+// tally returns the address reported by most sources and its vote count,
+// optionally counting healthy sources only. Callers hold mu.
+func (d *detector) tally(votes []vote, healthyOnly bool) (netip.Addr, int) {
+	counts := make(map[netip.Addr]int)
+	var winner netip.Addr
+	for _, v := range votes {
+		if v.err != nil || (healthyOnly && d.health[v.source.Name()] == 0) {
+			continue
+		}
+		counts[v.addr]++
+		if counts[v.addr] > counts[winner] {
+			winner = v.addr
+		}
+	}
+	return winner, counts[winner]
+}
+
+// adjust changes the health of s by delta within [0, maxSourceHealth]. Callers hold mu.
+func (d *detector) adjust(s ipSource, delta int) {
+	name := s.Name()
+	before := d.health[name]
+	after := min(max(before+delta, 0), maxSourceHealth)
+	d.health[name] = after
+	if before > 0 && after == 0 {
+		log.Printf("IP source %s demoted\n", name)
+	} else if before == 0 && after > 0 {
+		log.Printf("IP source %s recovered\n", name)
+	}
+}
+
+func familyName(ipv4 bool) string {
+	if ipv4 {
+		return "IPv4"
+	}
+	return "IPv6"
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/netip"
+	"slices"
+	"testing"
+)
+
+// fixedSource always reports the same address or error.
+type fixedSource struct {
+	name string
+	addr string
+	err  error
+}
+
+func (s *fixedSource) Name() string { return s.name }
+
+func (s *fixedSource) Detect(ctx context.Context, ipv4 bool) (netip.Addr, error) {
+	if s.err != nil {
+		return netip.Addr{}, s.err
+	}
+	return netip.ParseAddr(s.addr)
+}
+
+func TestDetectorQuorum(t *testing.T) {
+	portal := &fixedSource{name: "portal", addr: "192.0.2.99"}
+	d := newDetector([]ipSource{
+		&fixedSource{name: "a", addr: "198.51.100.1"},
+		&fixedSource{name: "b", addr: "198.51.100.1"},
+		portal,
+	}, 2)
+
+	for i := 0; i < maxSourceHealth; i++ {
+		ip, err := d.detect(context.Background(), true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ip != "198.51.100.1" {
+			t.Fatalf("got %s, want 198.51.100.1", ip)
+		}
+	}
+	if h := d.health["portal"]; h != 0 {
+		t.Fatalf("disagreeing source has health %d, want it demoted", h)
+	}
+
+	// the demoted source recovers once it agrees again
+	portal.addr = "198.51.100.1"
+	if _, err := d.detect(context.Background(), true); err != nil {
+		t.Fatal(err)
+	}
+	if h := d.health["portal"]; h != 1 {
+		t.Fatalf("agreeing source has health %d, want 1", h)
+	}
+}
+
+func TestDetectorNoQuorum(t *testing.T) {
+	d := newDetector([]ipSource{
+		&fixedSource{name: "a", addr: "198.51.100.1"},
+		&fixedSource{name: "b", addr: "198.51.100.2"},
+		&fixedSource{name: "c", err: errors.New("timeout")},
+	}, 2)
+	if ip, err := d.detect(context.Background(), true); err == nil {
+		t.Fatalf("got %s without quorum", ip)
+	}
+}
+
+func TestDetectorRejectsWrongFamily(t *testing.T) {
+	d := newDetector([]ipSource{&fixedSource{name: "a", addr: "2001:db8::1"}}, 1)
+	if ip, err := d.detect(context.Background(), true); err == nil {
+		t.Fatalf("got %s for IPv4", ip)
+	}
+}
+
+func TestSplitSpecs(t *testing.T) {
+	got := splitSpecs(`https://example.com/#regex=(\d{1,3}(\.\d{1,3}){3}), stun:stun.l.google.com:19302,,cmd:wan-ip ppp0,1,`)
+	want := []string{`https://example.com/#regex=(\d{1,3}(\.\d{1,3}){3})`, " stun:stun.l.google.com:19302", "cmd:wan-ip ppp0,1"}
+	if !slices.Equal(got, want) {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+	d, err := newDetectorFromSpecs(`https://example.com/#regex=(\d{1,3}(\.\d{1,3}){3}),stun:stun.l.google.com:19302`, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(d.sources) != 2 {
+		t.Errorf("got %d sources, want 2", len(d.sources))
+	}
+}
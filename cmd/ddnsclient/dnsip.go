@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/netip"
+	"net/url"
+	"strings"
+)
+
+func init() {
+	registerIPSource("dns", newDNSSource)
+}
+
+// dnsSource asks a resolver for a special name that resolves to the address
+// the query came from, e.g. "dns://resolver1.opendns.com/myip.opendns.com"
+// for an A/AAAA answer or "dns://ns1.google.com/o-o.myaddr.l.google.com?type=TXT"
+// for a TXT answer. The query is sent over the requested address family.
+type dnsSource struct {
+	server string
+	name   string
+	txt    bool
+}
+
+func newDNSSource(spec string) (ipSource, error) {
+	u, err := url.Parse(spec)
+	if err != nil {
+		return nil, err
+	}
+	if len(u.Host) == 0 || len(strings.Trim(u.Path, "/")) == 0 {
+		return nil, fmt.Errorf("DNS source %q should look like dns://resolver/name", spec)
+	}
+	s := &dnsSource{
+		server: u.Host,
+		name:   strings.Trim(u.Path, "/") + ".",
+	}
+	if len(u.Port()) == 0 {
+		s.server = net.JoinHostPort(u.Hostname(), "53")
+	}
+	switch t := strings.ToUpper(u.Query().Get("type")); t {
+	case "", "A", "AAAA":
+	case "TXT":
+		s.txt = true
+	default:
+		return nil, fmt.Errorf("unsupported DNS record type %q", t)
+	}
+	return s, nil
+}
+
+func (s *dnsSource) Name() string {
+	name := "dns://" + s.server + "/" + strings.TrimSuffix(s.name, ".")
+	if s.txt {
+		name += "?type=TXT"
+	}
+	return name
+}
+
+func (s *dnsSource) Detect(ctx context.Context, ipv4 bool) (netip.Addr, error) {
+	suffix := "6"
+	if ipv4 {
+		suffix = "4"
+	}
+	resolver := &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+			// ignore the system resolver, talk to the configured one over the requested family
+			return dialContext(ctx, network+suffix, s.server)
+		},
+	}
+
+	if !s.txt {
+		addrs, err := resolver.LookupNetIP(ctx, "ip"+suffix, s.name)
+		if err != nil {
+			return netip.Addr{}, err
+		}
+		return addrs[0], nil
+	}
+
+	records, err := resolver.LookupTXT(ctx, s.name)
+	if err != nil {
+		return netip.Addr{}, err
+	}
+	for _, record := range records {
+		// other records, like "edns0-client-subnet 192.0.2.0/24", are skipped
+		if addr, err := netip.ParseAddr(strings.TrimSpace(record)); err == nil && addr.Unmap().Is4() == ipv4 {
+			return addr, nil
+		}
+	}
+	return netip.Addr{}, fmt.Errorf("no %s address in TXT records %q of %s", familyName(ipv4), records, s.name)
+}
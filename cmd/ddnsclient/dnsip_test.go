@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+)
+
+// serveDNS answers every query on conn with records of the queried name and
+// type, one for each of its rdata.
+func serveDNS(conn net.PacketConn, rdata map[uint16][][]byte) {
+	buf := make([]byte, 1500)
+	for {
+		n, from, err := conn.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		msg := buf[:n]
+		if n < 12 || binary.BigEndian.Uint16(msg[4:]) != 1 {
+			continue
+		}
+		// the question is the name up to the root label, then type and class
+		end := 12
+		for end < n && msg[end] != 0 {
+			end += int(msg[end]) + 1
+		}
+		end += 5
+		if end > n {
+			continue
+		}
+		question := msg[12:end]
+		qtype := binary.BigEndian.Uint16(msg[end-4:])
+
+		resp := make([]byte, 12, 512)
+		copy(resp, msg[:2])
+		binary.BigEndian.PutUint16(resp[2:], 0x8180)
+		binary.BigEndian.PutUint16(resp[4:], 1)
+		resp = append(resp, question...)
+		binary.BigEndian.PutUint16(resp[6:], uint16(len(rdata[qtype])))
+		for _, data := range rdata[qtype] {
+			resp = append(resp, 0xc0, 12)
+			resp = binary.BigEndian.AppendUint16(resp, qtype)
+			resp = binary.BigEndian.AppendUint16(resp, 1)
+			resp = binary.BigEndian.AppendUint32(resp, 0)
+			resp = binary.BigEndian.AppendUint16(resp, uint16(len(data)))
+			resp = append(resp, data...)
+		}
+		conn.WriteTo(resp, from)
+	}
+}
+
+func txtRecord(value string) []byte {
+	return append([]byte{byte(len(value))}, value...)
+}
+
+func TestDNSSource(t *testing.T) {
+	conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	go serveDNS(conn, map[uint16][][]byte{
+		1:  {{203, 0, 113, 7}},
+		16: {txtRecord("edns0-client-subnet 198.51.100.0/24"), txtRecord("203.0.113.8")},
+	})
+
+	for spec, want := range map[string]string{
+		"dns://" + conn.LocalAddr().String() + "/myip.opendns.com":                 "203.0.113.7",
+		"dns://" + conn.LocalAddr().String() + "/o-o.myaddr.l.google.com?type=TXT": "203.0.113.8",
+	} {
+		src, err := newIPSource(spec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		addr, err := src.Detect(ctx, true)
+		cancel()
+		if err != nil {
+			t.Fatalf("%s: %v", spec, err)
+		}
+		if addr.String() != want {
+			t.Fatalf("%s: got %s, want %s", spec, addr, want)
+		}
+	}
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -8,311 +9,216 @@ import (
 	"net/http"
 	"net/url"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/missdeer/ddnsclient/models"
 )
 
-var (
-	dnspodDomainList = &models.DnspodDomainList{}
+const (
+	dnspodStatusOK        = "1"
+	dnspodStatusNoRecords = "10"
 )
 
-func dnspodRequestByToken(id string, token string, domain string, subDomain string, isInternal bool) error {
-	needDomainList := false
-	if len(dnspodDomainList.Domains) == 0 {
-		needDomainList = true
-	}
-	var domainId int = 0
-	if needDomainList == false {
-		needDomainList = true
-		for _, v := range dnspodDomainList.Domains {
-			if v.Name == domain {
-				needDomainList = false
-				domainId = v.Id
-				break
-			}
-		}
+func init() {
+	registerProvider("dnspod", newDnspodProvider)
+}
+
+// dnspodProvider manages a record through the DNSPod API, authorized either
+// by an API token or by the account email and password.
+type dnspodProvider struct {
+	item     models.DnspodConfigurationItem
+	domainId int
+}
+
+func newDnspodProvider(raw json.RawMessage) (Provider, error) {
+	p := &dnspodProvider{}
+	if err := json.Unmarshal(raw, &p.item); err != nil {
+		return nil, err
 	}
+	return p, nil
+}
 
-	client := &http.Client{}
-	if needDomainList {
-		// get domainn id first
-		domainListUrl := "https://dnsapi.cn/Domain.List"
-		resp, err := client.PostForm(domainListUrl, url.Values{
-			"login_token": {id + "," + token},
-			"format":      {"json"},
-		})
-		if err != nil {
-			fmt.Printf("request domain list failed\n")
-			return err
-		}
-		defer resp.Body.Close()
+func (p *dnspodProvider) Name() string {
+	return "dnspod:" + fullDomain(p.item.SubDomain, p.item.Domain)
+}
 
-		body, err := ioutil.ReadAll(resp.Body)
-		if err != nil {
-			fmt.Printf("reading domain list failed\n")
-			return err
-		}
+func (p *dnspodProvider) Options() *models.RecordOptions {
+	return &p.item.RecordOptions
+}
 
-		if err = json.Unmarshal(body, &dnspodDomainList); err != nil {
-			fmt.Printf("unmarshalling domain list %s failed\n", string(body))
-			return err
-		}
+func (p *dnspodProvider) Validate() error {
+	if len(p.item.Domain) == 0 {
+		return errors.New("missing domain")
 	}
-	foundDomain := false
-	for _, v := range dnspodDomainList.Domains {
-		if v.Name == domain {
-			foundDomain = true
-			domainId = v.Id
-			break
-		}
+	if (len(p.item.Token) == 0 || len(p.item.TokenId) == 0) && (len(p.item.UserName) == 0 || len(p.item.Password) == 0) {
+		return errors.New("either id and token or username and password are required")
 	}
+	return nil
+}
 
-	if foundDomain == false {
-		fmt.Printf("domain %s doesn't exists\n", domain)
-		return errors.New("domain not found")
+func (p *dnspodProvider) CurrentRecord(ctx context.Context, recordType string) (*Record, error) {
+	domainId, err := p.findDomain(ctx)
+	if err != nil {
+		return nil, err
 	}
 
 	// check record list
-	recordListUrl := "https://dnsapi.cn/Record.List"
-	resp, err := client.PostForm(recordListUrl, url.Values{
-		"login_token": {id + "," + token},
-		"format":      {"json"},
+	recordList := new(models.DnspodRecordList)
+	err = p.post(ctx, "https://dnsapi.cn/Record.List", url.Values{
 		"domain_id":   {strconv.Itoa(domainId)},
-	})
+		"sub_domain":  {p.item.SubDomain},
+		"record_type": {recordType},
+	}, recordList)
 	if err != nil {
-		fmt.Printf("request record list failed\n")
-		return err
+		return nil, err
 	}
-	defer resp.Body.Close()
-
-	body, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		fmt.Printf("reading record list failed\n")
-		return err
+	if recordList.Status.Code == dnspodStatusNoRecords {
+		return nil, nil
 	}
-
-	recordList := new(models.DnspodRecordList)
-	if err = json.Unmarshal(body, recordList); err != nil {
-		fmt.Printf("unmarshalling record list %s failed\n", string(body))
-		fmt.Println(err)
-		return err
+	if recordList.Status.Code != dnspodStatusOK {
+		return nil, dnspodError("Record.List", recordList.Status)
 	}
-	foundRecord := false
-	var recordID string
 	for _, v := range recordList.Records {
-		if v.Name == subDomain {
-			foundRecord = true
-			recordID = v.Id
-			break
+		if v.Name == p.item.SubDomain && v.Type == recordType {
+			return &Record{ID: v.Id, Type: v.Type, Value: v.Value}, nil
 		}
 	}
+	return nil, nil
+}
 
-	newIP := currentExternalIPv4
-	if isInternal {
-		newIP = currentInternalIPv4
+func (p *dnspodProvider) Upsert(ctx context.Context, record *Record, recordType string, value string) error {
+	domainId, err := p.findDomain(ctx)
+	if err != nil {
+		return err
 	}
-	if foundRecord == false {
-		// if the sub domain doesn't exist, add one
-		addRecordURL := "https://dnsapi.cn/Record.Create"
-		resp, err := client.PostForm(addRecordURL, url.Values{
-			"login_token": {id + "," + token},
-			"format":      {"json"},
-			"domain_id":   {strconv.Itoa(domainId)},
-			"sub_domain":  {subDomain},
-			"record_type": {"A"},
-			"record_line": {"默认"},
-			"value":       {newIP},
-		})
-		if err != nil {
-			fmt.Printf("request record insert failed\n")
-			return err
-		}
-		defer resp.Body.Close()
-
-		if _, err = ioutil.ReadAll(resp.Body); err != nil {
-			fmt.Printf("reading record insert response failed\n")
-			return err
-		}
 
-		fmt.Printf("[%v] A record inserted into DNSPOD: %s.%s => %s\n", time.Now(), subDomain, domain, newIP)
-	} else {
+	values := url.Values{
+		"domain_id":   {strconv.Itoa(domainId)},
+		"sub_domain":  {p.item.SubDomain},
+		"record_type": {recordType},
+		"record_line": {"默认"},
+		"value":       {value},
+	}
+	action := "Record.Create"
+	if record != nil {
 		// otherwise just update it
-		modifyRecordURL := "https://dnsapi.cn/Record.Modify"
-		resp, err := client.PostForm(modifyRecordURL, url.Values{
-			"login_token": {id + "," + token},
-			"format":      {"json"},
-			"record_id":   {recordID},
-			"domain_id":   {strconv.Itoa(domainId)},
-			"sub_domain":  {subDomain},
-			"record_type": {"A"},
-			"record_line": {"默认"},
-			"value":       {newIP},
-		})
-		if err != nil {
-			fmt.Printf("request record modify failed\n")
-			return err
-		}
-		defer resp.Body.Close()
-
-		if _, err = ioutil.ReadAll(resp.Body); err != nil {
-			fmt.Printf("reading record modify response failed\n")
-			return err
-		}
-		fmt.Printf("[%v] A record updated to DNSPOD: %s.%s => %s\n", time.Now(), subDomain, domain, newIP)
+		action = "Record.Modify"
+		values.Set("record_id", record.ID)
+	}
+	resp := new(models.DnspodResponse)
+	if err = p.post(ctx, "https://dnsapi.cn/"+action, values, resp); err != nil {
+		return err
+	}
+	if resp.Status.Code != dnspodStatusOK {
+		return dnspodError(action, resp.Status)
 	}
 
+	if record == nil {
+		fmt.Printf("[%v] %s record inserted into DNSPOD: %s.%s => %s\n", time.Now(), recordType, p.item.SubDomain, p.item.Domain, value)
+	} else {
+		fmt.Printf("[%v] %s record updated to DNSPOD: %s.%s => %s\n", time.Now(), recordType, p.item.SubDomain, p.item.Domain, value)
+	}
 	return nil
 }
 
-func dnspodRequest(user string, password string, domain string, subDomain string, isInternal bool) error {
-	needDomainList := false
-	if len(dnspodDomainList.Domains) == 0 {
-		needDomainList = true
+func (p *dnspodProvider) Delete(ctx context.Context, recordType string) error {
+	record, err := p.CurrentRecord(ctx, recordType)
+	if err != nil || record == nil {
+		return err
 	}
-	var domainId int = 0
-	if needDomainList == false {
-		needDomainList = true
-		for _, v := range dnspodDomainList.Domains {
-			if v.Name == domain {
-				needDomainList = false
-				domainId = v.Id
-				break
-			}
-		}
+	resp := new(models.DnspodResponse)
+	err = p.post(ctx, "https://dnsapi.cn/Record.Remove", url.Values{
+		"domain_id": {strconv.Itoa(p.domainId)},
+		"record_id": {record.ID},
+	}, resp)
+	if err != nil {
+		return err
 	}
+	if resp.Status.Code != dnspodStatusOK {
+		return dnspodError("Record.Remove", resp.Status)
+	}
+	fmt.Printf("[%v] %s record removed from DNSPOD: %s.%s\n", time.Now(), recordType, p.item.SubDomain, p.item.Domain)
+	return nil
+}
 
-	client := &http.Client{}
-	if needDomainList {
-		// get domainn id first
-		domainListUrl := "https://dnsapi.cn/Domain.List"
-		resp, err := client.PostForm(domainListUrl, url.Values{
-			"login_email":    {user},
-			"login_password": {password},
-			"format":         {"json"},
-		})
-		if err != nil {
-			fmt.Printf("request domain list failed\n")
-			return err
-		}
-		defer resp.Body.Close()
-
-		body, err := ioutil.ReadAll(resp.Body)
-		if err != nil {
-			fmt.Printf("reading domain list failed\n")
-			return err
-		}
-
-		if err = json.Unmarshal(body, &dnspodDomainList); err != nil {
-			fmt.Printf("unmarshalling domain list %s failed\n", string(body))
-			return err
-		}
+// findDomain returns the DNSPod domain id of the configured domain, the
+// domain list is only requested the first time.
+func (p *dnspodProvider) findDomain(ctx context.Context) (int, error) {
+	if p.domainId != 0 {
+		return p.domainId, nil
+	}
+	domainList := new(models.DnspodDomainList)
+	if err := p.post(ctx, "https://dnsapi.cn/Domain.List", url.Values{}, domainList); err != nil {
+		return 0, err
 	}
-	foundDomain := false
-	for _, v := range dnspodDomainList.Domains {
-		if v.Name == domain {
-			foundDomain = true
-			domainId = v.Id
-			break
+	if domainList.Status.Code != dnspodStatusOK {
+		return 0, dnspodError("Domain.List", domainList.Status)
+	}
+	for _, v := range domainList.Domains {
+		if v.Name == p.item.Domain {
+			p.domainId = v.Id
+			return v.Id, nil
 		}
 	}
+	fmt.Printf("domain %s doesn't exists\n", p.item.Domain)
+	return 0, notFoundError(errors.New("domain not found"))
+}
 
-	if foundDomain == false {
-		fmt.Printf("domain %s doesn't exists\n", domain)
-		return errors.New("domain not found")
+// post sends an authorized API request and unmarshals the JSON response into v.
+func (p *dnspodProvider) post(ctx context.Context, apiURL string, values url.Values, v interface{}) error {
+	if len(p.item.Token) != 0 && len(p.item.TokenId) != 0 {
+		values.Set("login_token", p.item.TokenId+","+p.item.Token)
+	} else {
+		values.Set("login_email", p.item.UserName)
+		values.Set("login_password", p.item.Password)
 	}
+	values.Set("format", "json")
 
-	// check record list
-	recordListUrl := "https://dnsapi.cn/Record.List"
-	resp, err := client.PostForm(recordListUrl, url.Values{
-		"login_email":    {user},
-		"login_password": {password},
-		"format":         {"json"},
-		"domain_id":      {strconv.Itoa(domainId)},
-	})
+	req, err := http.NewRequestWithContext(ctx, "POST", apiURL, strings.NewReader(values.Encode()))
 	if err != nil {
-		fmt.Printf("request record list failed\n")
 		return err
 	}
-	defer resp.Body.Close()
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 
-	body, err := ioutil.ReadAll(resp.Body)
+	client := &http.Client{}
+	resp, err := client.Do(req)
 	if err != nil {
-		fmt.Printf("reading record list failed\n")
+		fmt.Printf("request %s failed\n", apiURL)
 		return err
 	}
-
-	recordList := new(models.DnspodRecordList)
-	if err = json.Unmarshal(body, recordList); err != nil {
-		fmt.Printf("unmarshalling record list %s failed\n", string(body))
-		fmt.Println(err)
+	defer resp.Body.Close()
+	if err = checkResponse(resp); err != nil {
 		return err
 	}
-	foundRecord := false
-	var recordID string
-	for _, v := range recordList.Records {
-		if v.Name == subDomain {
-			foundRecord = true
-			recordID = v.Id
-			break
-		}
-	}
 
-	newIP := currentExternalIPv4
-	if isInternal {
-		newIP = currentInternalIPv4
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		fmt.Printf("reading %s response failed\n", apiURL)
+		return err
 	}
-	if foundRecord == false {
-		// if the sub domain doesn't exist, add one
-		addRecordURL := "https://dnsapi.cn/Record.Create"
-		resp, err := client.PostForm(addRecordURL, url.Values{
-			"login_email":    {user},
-			"login_password": {password},
-			"format":         {"json"},
-			"domain_id":      {strconv.Itoa(domainId)},
-			"sub_domain":     {subDomain},
-			"record_type":    {"A"},
-			"record_line":    {"默认"},
-			"value":          {newIP},
-		})
-		if err != nil {
-			fmt.Printf("request record insert failed\n")
-			return err
-		}
-		defer resp.Body.Close()
-
-		if _, err = ioutil.ReadAll(resp.Body); err != nil {
-			fmt.Printf("reading record insert response failed\n")
-			return err
-		}
 
-		fmt.Printf("[%v] A record inserted into DNSPOD: %s.%s => %s\n", time.Now(), subDomain, domain, newIP)
-	} else {
-		// otherwise just update it
-		modifyRecordURL := "https://dnsapi.cn/Record.Modify"
-		resp, err := client.PostForm(modifyRecordURL, url.Values{
-			"login_email":    {user},
-			"login_password": {password},
-			"format":         {"json"},
-			"record_id":      {recordID},
-			"domain_id":      {strconv.Itoa(domainId)},
-			"sub_domain":     {subDomain},
-			"record_type":    {"A"},
-			"record_line":    {"默认"},
-			"value":          {newIP},
-		})
-		if err != nil {
-			fmt.Printf("request record modify failed\n")
-			return err
-		}
-		defer resp.Body.Close()
-
-		if _, err = ioutil.ReadAll(resp.Body); err != nil {
-			fmt.Printf("reading record modify response failed\n")
-			return err
-		}
-		fmt.Printf("[%v] A record updated to DNSPOD: %s.%s => %s\n", time.Now(), subDomain, domain, newIP)
+	if err = json.Unmarshal(body, v); err != nil {
+		fmt.Printf("unmarshalling %s response %s failed\n", apiURL, string(body))
+		return err
 	}
-
 	return nil
 }
+
+// dnspodError classifies a failed DNSPod API status.
+func dnspodError(action string, status models.DnspodStatus) error {
+	err := fmt.Errorf("DNSPOD %s failed: %s (%s)", action, status.Message, status.Code)
+	switch status.Code {
+	case "-1", "7":
+		// login failed, not the owner of the domain
+		return authError(err)
+	case "6", "8":
+		// invalid domain id, invalid record id
+		return notFoundError(err)
+	case "-2", "":
+		// API usage exceeded, or no status in the response at all
+		return transientError(err, 0)
+	default:
+		return validationError(err)
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// errorKind classifies provider failures, only transient ones are worth retrying.
+type errorKind int
+
+const (
+	errorTransient errorKind = iota
+	errorAuth
+	errorNotFound
+	errorValidation
+)
+
+func (k errorKind) String() string {
+	switch k {
+	case errorAuth:
+		return "auth"
+	case errorNotFound:
+		return "not found"
+	case errorValidation:
+		return "validation"
+	default:
+		return "transient"
+	}
+}
+
+// providerError wraps an error returned by a DNS service with its kind. Errors
+// which are not a providerError, such as network failures, are transient.
+type providerError struct {
+	kind       errorKind
+	err        error
+	retryAfter time.Duration
+}
+
+func (e *providerError) Error() string {
+	return fmt.Sprintf("%s error: %v", e.kind, e.err)
+}
+
+func (e *providerError) Unwrap() error {
+	return e.err
+}
+
+func authError(err error) error {
+	return &providerError{kind: errorAuth, err: err}
+}
+
+func notFoundError(err error) error {
+	return &providerError{kind: errorNotFound, err: err}
+}
+
+func validationError(err error) error {
+	return &providerError{kind: errorValidation, err: err}
+}
+
+func transientError(err error, retryAfter time.Duration) error {
+	return &providerError{kind: errorTransient, err: err, retryAfter: retryAfter}
+}
+
+// isPermanent reports whether retrying can't make err go away.
+func isPermanent(err error) bool {
+	var pe *providerError
+	return errors.As(err, &pe) && pe.kind != errorTransient
+}
+
+// retryAfter returns the delay requested by the service, or 0.
+func retryAfter(err error) time.Duration {
+	var pe *providerError
+	if errors.As(err, &pe) {
+		return pe.retryAfter
+	}
+	return 0
+}
+
+// checkResponse classifies an unsuccessful HTTP response by its status code.
+func checkResponse(resp *http.Response) error {
+	if resp.StatusCode < http.StatusBadRequest {
+		return nil
+	}
+	err := fmt.Errorf("%s %s: %s", resp.Request.Method, resp.Request.URL.Host, resp.Status)
+	switch {
+	case resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden:
+		return authError(err)
+	case resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusGone:
+		return notFoundError(err)
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= http.StatusInternalServerError:
+		return transientError(err, parseRetryAfter(resp.Header.Get("Retry-After")))
+	default:
+		return validationError(err)
+	}
+}
+
+// parseRetryAfter parses a Retry-After header given either in seconds or as an HTTP date.
+func parseRetryAfter(value string) time.Duration {
+	if len(value) == 0 {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if t, err := http.ParseTime(value); err == nil {
+		if d := time.Until(t); d > 0 {
+			return d
+		}
+	}
+	return 0
+}
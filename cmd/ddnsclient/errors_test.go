@@ -0,0 +1,147 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/cloudflare/cloudflare-go"
+	"github.com/missdeer/ddnsclient/models"
+)
+
+// errorKindOf describes how err is classified: "nil", the kind of a
+// providerError or "plain" for other errors, which are retried too.
+func errorKindOf(err error) string {
+	var pe *providerError
+	switch {
+	case err == nil:
+		return "nil"
+	case errors.As(err, &pe):
+		return pe.kind.String()
+	default:
+		return "plain"
+	}
+}
+
+func TestCheckResponse(t *testing.T) {
+	for _, tc := range []struct {
+		status     int
+		retryAfter string
+		kind       string
+		delay      time.Duration
+	}{
+		{http.StatusOK, "", "nil", 0},
+		{http.StatusNotModified, "", "nil", 0},
+		{http.StatusBadRequest, "", "validation", 0},
+		{http.StatusUnauthorized, "", "auth", 0},
+		{http.StatusForbidden, "", "auth", 0},
+		{http.StatusNotFound, "", "not found", 0},
+		{http.StatusGone, "", "not found", 0},
+		{http.StatusConflict, "", "validation", 0},
+		{http.StatusTooManyRequests, "120", "transient", 2 * time.Minute},
+		{http.StatusInternalServerError, "", "transient", 0},
+		{http.StatusServiceUnavailable, "30", "transient", 30 * time.Second},
+	} {
+		resp := &http.Response{
+			StatusCode: tc.status,
+			Status:     http.StatusText(tc.status),
+			Header:     http.Header{"Retry-After": {tc.retryAfter}},
+			Request:    &http.Request{Method: "GET", URL: &url.URL{Host: "dnsapi.example.com"}},
+		}
+		err := checkResponse(resp)
+		if got := errorKindOf(err); got != tc.kind {
+			t.Errorf("%d: got %s, want %s", tc.status, got, tc.kind)
+		}
+		if got := retryAfter(err); got != tc.delay {
+			t.Errorf("%d: retry after %v, want %v", tc.status, got, tc.delay)
+		}
+		if got, want := isPermanent(err), tc.kind != "nil" && tc.kind != "transient"; got != want {
+			t.Errorf("%d: permanent %v, want %v", tc.status, got, want)
+		}
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	for _, tc := range []struct {
+		value    string
+		min, max time.Duration
+	}{
+		{"", 0, 0},
+		{"90", 90 * time.Second, 90 * time.Second},
+		{"0", 0, 0},
+		{"-5", 0, 0},
+		{"soon", 0, 0},
+		{time.Now().Add(time.Hour).UTC().Format(http.TimeFormat), 58 * time.Minute, time.Hour},
+		{time.Now().Add(-time.Hour).UTC().Format(http.TimeFormat), 0, 0},
+	} {
+		if got := parseRetryAfter(tc.value); got < tc.min || got > tc.max {
+			t.Errorf("%q: got %v, want %v to %v", tc.value, got, tc.min, tc.max)
+		}
+	}
+}
+
+func TestDnspodError(t *testing.T) {
+	for code, kind := range map[string]string{
+		"-1": "auth",
+		"7":  "auth",
+		"6":  "not found",
+		"8":  "not found",
+		"-2": "transient",
+		"":   "transient",
+		"2":  "validation",
+		"17": "validation",
+	} {
+		if got := errorKindOf(dnspodError("Record.Modify", models.DnspodStatus{Code: code, Message: "failed"})); got != kind {
+			t.Errorf("code %q: got %s, want %s", code, got, kind)
+		}
+	}
+}
+
+func TestBasicAuthResult(t *testing.T) {
+	for body, kind := range map[string]string{
+		"good 192.0.2.1":  "nil",
+		"nochg 192.0.2.1": "nil",
+		"":                "nil",
+		"badauth":         "auth",
+		"!donator":        "auth",
+		"nohost":          "not found",
+		"notfqdn":         "validation",
+		"numhost":         "validation",
+		"abuse":           "validation",
+		"badagent":        "validation",
+		"dnserr":          "transient",
+		"911":             "transient",
+	} {
+		if got := errorKindOf(basicAuthResult(body)); got != kind {
+			t.Errorf("%q: got %s, want %s", body, got, kind)
+		}
+	}
+}
+
+func TestCloudflareError(t *testing.T) {
+	apiErr := &cloudflare.Error{StatusCode: http.StatusBadRequest, ErrorMessages: []string{"failed"}}
+	authentication := cloudflare.NewAuthenticationError(apiErr)
+	authorization := cloudflare.NewAuthorizationError(apiErr)
+	notFound := cloudflare.NewNotFoundError(apiErr)
+	request := cloudflare.NewRequestError(apiErr)
+	ratelimit := cloudflare.NewRatelimitError(apiErr)
+	service := cloudflare.NewServiceError(apiErr)
+	for _, tc := range []struct {
+		err  error
+		kind string
+	}{
+		{&authentication, "auth"},
+		{&authorization, "auth"},
+		{&notFound, "not found"},
+		{&request, "validation"},
+		{&ratelimit, "plain"},
+		{&service, "plain"},
+		{errors.New("connection reset"), "plain"},
+	} {
+		if got := errorKindOf(cloudflareError(tc.err)); got != tc.kind {
+			t.Errorf("%T: got %s, want %s", tc.err, got, tc.kind)
+		}
+	}
+}
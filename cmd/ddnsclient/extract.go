@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/netip"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// responseExtractor returns the text holding the address from an ifconfig response.
+type responseExtractor func(resp *http.Response, body []byte) (string, error)
+
+// newResponseExtractor parses how to find the address in a response, given
+// as the fragment of the ifconfig URL which is never sent to the service:
+//
+//	https://ifconfig.minidump.info            the body is the address
+//	https://api.ipify.org?format=json#json=ip a JSON path, e.g. data.ips.0
+//	https://example.com/#regex=IP: ([0-9a-f.:]+) the first capture group of a regex
+//	https://example.com/#header=X-Client-IP   a response header
+func newResponseExtractor(fragment string) (responseExtractor, error) {
+	kind, arg, _ := strings.Cut(fragment, "=")
+	switch kind {
+	case "", "text":
+		return func(resp *http.Response, body []byte) (string, error) {
+			return string(body), nil
+		}, nil
+	case "json":
+		path := strings.Split(arg, ".")
+		if len(arg) == 0 {
+			return nil, fmt.Errorf("empty JSON path in %q", fragment)
+		}
+		return func(resp *http.Response, body []byte) (string, error) {
+			return jsonPath(body, path)
+		}, nil
+	case "regex":
+		re, err := regexp.Compile(arg)
+		if err != nil {
+			return nil, err
+		}
+		return func(resp *http.Response, body []byte) (string, error) {
+			m := re.FindSubmatch(body)
+			if m == nil {
+				return "", fmt.Errorf("%s doesn't match the response", re)
+			}
+			if len(m) > 1 {
+				return string(m[1]), nil
+			}
+			return string(m[0]), nil
+		}, nil
+	case "header":
+		if len(arg) == 0 {
+			return nil, fmt.Errorf("empty header name in %q", fragment)
+		}
+		return func(resp *http.Response, body []byte) (string, error) {
+			value := resp.Header.Get(arg)
+			if len(value) == 0 {
+				return "", fmt.Errorf("response has no %s header", arg)
+			}
+			return value, nil
+		}, nil
+	}
+	return nil, fmt.Errorf("unknown response format %q, available values: text, json, regex, header", kind)
+}
+
+// jsonPath returns the string at path in the JSON document body, array
+// elements are selected by their index.
+func jsonPath(body []byte, path []string) (string, error) {
+	var v any
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	if err := decoder.Decode(&v); err != nil {
+		return "", err
+	}
+	for i, key := range path {
+		switch node := v.(type) {
+		case map[string]any:
+			var ok bool
+			if v, ok = node[key]; !ok {
+				return "", fmt.Errorf("JSON response has no %s", strings.Join(path[:i+1], "."))
+			}
+		case []any:
+			index, err := strconv.Atoi(key)
+			if err != nil || index < 0 || index >= len(node) {
+				return "", fmt.Errorf("JSON response has no %s", strings.Join(path[:i+1], "."))
+			}
+			v = node[index]
+		default:
+			return "", fmt.Errorf("JSON response has no %s", strings.Join(path[:i+1], "."))
+		}
+	}
+	s, ok := v.(string)
+	if !ok {
+		return "", fmt.Errorf("%s in JSON response isn't a string", strings.Join(path, "."))
+	}
+	return s, nil
+}
+
+// parseAddr parses s as a single IPv4 or IPv6 address, surrounding white
+// space is ignored.
+func parseAddr(s string) (netip.Addr, error) {
+	s = strings.TrimSpace(s)
+	addr, err := netip.ParseAddr(s)
+	if err != nil || len(addr.Zone()) != 0 {
+		if len(s) > 64 {
+			s = s[:64] + "..."
+		}
+		return netip.Addr{}, fmt.Errorf("invalid IP address: %q", s)
+	}
+	return addr.Unmap(), nil
+}
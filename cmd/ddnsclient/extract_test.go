@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestFetchExternalIPFormats(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/text", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("2001:db8::abcd\n"))
+	})
+	mux.HandleFunc("/json", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"data": {"ips": [{"ip": "203.0.113.1"}, {"ip": "203.0.113.2"}]}}`))
+	})
+	mux.HandleFunc("/html", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("<html><body>Your IP: <b>198.51.100.3</b></body></html>"))
+	})
+	mux.HandleFunc("/header", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Client-IP", "192.0.2.4")
+	})
+	mux.HandleFunc("/down", func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "203.0.113.9", http.StatusServiceUnavailable)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	for _, tc := range []struct {
+		path string
+		want string
+	}{
+		{"/text", "2001:db8::abcd"},
+		{"/json#json=data.ips.1.ip", "203.0.113.2"},
+		{"/html#regex=<b>([0-9.]+)</b>", "198.51.100.3"},
+		{"/header#header=X-Client-IP", "192.0.2.4"},
+		{"/html", ""},
+		{"/json#json=data.ips.5.ip", ""},
+		{"/json#json=data", ""},
+		{"/header", ""},
+		{"/down", ""},
+	} {
+		ip, err := fetchExternalIP(context.Background(), server.URL+tc.path, true)
+		if len(tc.want) == 0 {
+			if err == nil {
+				t.Errorf("%s: got %s, want an error", tc.path, ip)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tc.path, err)
+		} else if ip != tc.want {
+			t.Errorf("%s: got %s, want %s", tc.path, ip, tc.want)
+		}
+	}
+}
+
+func TestInvalidResponseFormat(t *testing.T) {
+	for _, spec := range []string{"https://example.com/#xml=ip", "https://example.com/#regex=(", "https://example.com/#json="} {
+		if _, err := newIPSource(spec); err == nil {
+			t.Errorf("%s accepted", spec)
+		}
+	}
+}
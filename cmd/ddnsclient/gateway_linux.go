@@ -0,0 +1,43 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"net/netip"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// defaultGateway returns the IPv4 default gateway from the kernel routing table.
+func defaultGateway() (netip.Addr, error) {
+	f, err := os.Open("/proc/net/route")
+	if err != nil {
+		return netip.Addr{}, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		// Iface Destination Gateway Flags ..., addresses are the network order
+		// bytes printed as a host order hex number
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 3 || fields[1] != "00000000" {
+			continue
+		}
+		v, err := strconv.ParseUint(fields[2], 16, 32)
+		if err != nil {
+			continue
+		}
+		var ip [4]byte
+		binary.NativeEndian.PutUint32(ip[:], uint32(v))
+		if gw := netip.AddrFrom4(ip); !gw.IsUnspecified() {
+			return gw, nil
+		}
+	}
+	if err = scanner.Err(); err != nil {
+		return netip.Addr{}, err
+	}
+	return netip.Addr{}, errors.New("no IPv4 default gateway")
+}
@@ -0,0 +1,13 @@
+//go:build !linux
+
+package main
+
+import (
+	"errors"
+	"net/netip"
+)
+
+// defaultGateway isn't implemented on this platform, the gateway has to be configured.
+func defaultGateway() (netip.Addr, error) {
+	return netip.Addr{}, errors.New("default gateway detection is only supported on Linux, specify the gateway address")
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/netip"
+
+	"github.com/missdeer/ddnsclient/models"
+)
+
+// allowBogons disables the rejection of reserved external addresses.
+var allowBogons bool
+
+// cgnatPrefix is the shared address space of carrier-grade NAT (RFC 6598).
+var cgnatPrefix = netip.MustParsePrefix("100.64.0.0/10")
+
+// bogonPrefixes are reserved ranges which are never reachable from the
+// internet, a detected external address in them is most likely wrong, e.g.
+// the answer of a captive portal or a router behind another NAT.
+var bogonPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),
+	netip.MustParsePrefix("10.0.0.0/8"),
+	cgnatPrefix,
+	netip.MustParsePrefix("127.0.0.0/8"),
+	netip.MustParsePrefix("169.254.0.0/16"),
+	netip.MustParsePrefix("172.16.0.0/12"),
+	netip.MustParsePrefix("192.0.0.0/24"),
+	netip.MustParsePrefix("192.0.2.0/24"),
+	netip.MustParsePrefix("192.168.0.0/16"),
+	netip.MustParsePrefix("198.18.0.0/15"),
+	netip.MustParsePrefix("198.51.100.0/24"),
+	netip.MustParsePrefix("203.0.113.0/24"),
+	netip.MustParsePrefix("224.0.0.0/4"),
+	netip.MustParsePrefix("240.0.0.0/4"),
+	netip.MustParsePrefix("::/128"),
+	netip.MustParsePrefix("::1/128"),
+	netip.MustParsePrefix("::ffff:0:0/96"),
+	netip.MustParsePrefix("100::/64"),
+	netip.MustParsePrefix("2001:db8::/32"),
+	netip.MustParsePrefix("fc00::/7"),
+	netip.MustParsePrefix("fe80::/10"),
+	netip.MustParsePrefix("ff00::/8"),
+}
+
+// checkAddress reports why value shouldn't be published by a record with
+// the options o. With an allowlist the address has to be in it, otherwise
+// external addresses in reserved ranges are rejected unless -allowBogons is set.
+func checkAddress(o *models.RecordOptions, value string) error {
+	addr, err := netip.ParseAddr(value)
+	if err != nil {
+		return err
+	}
+	if len(o.Allow) != 0 {
+		for _, cidr := range o.Allow {
+			if prefix, err := netip.ParsePrefix(cidr); err == nil && prefix.Contains(addr) {
+				return nil
+			}
+		}
+		return fmt.Errorf("%s is not in the allowed ranges %v", addr, o.Allow)
+	}
+	if o.Internal || allowBogons {
+		return nil
+	}
+	if cgnatPrefix.Contains(addr) {
+		return fmt.Errorf("%s is a carrier-grade NAT address (%s) which can't be reached from the internet, "+
+			"ask the ISP for a public IPv4 address or publish AAAA records only with \"stack\": \"ipv6\"", addr, cgnatPrefix)
+	}
+	for _, prefix := range bogonPrefixes {
+		if prefix.Contains(addr) {
+			return fmt.Errorf("%s is in the reserved range %s", addr, prefix)
+		}
+	}
+	return nil
+}
+
+// guardValues drops the values p must not publish and logs why.
+func guardValues(p Provider, values map[string]string) map[string]string {
+	for recordType, value := range values {
+		if err := checkAddress(p.Options(), value); err != nil {
+			log.Printf("not publishing %s record of %s: %v\n", recordType, p.Name(), err)
+			delete(values, recordType)
+		}
+	}
+	return values
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/missdeer/ddnsclient/models"
+)
+
+func TestCheckAddress(t *testing.T) {
+	external := &models.RecordOptions{}
+	internal := &models.RecordOptions{Internal: true}
+	allowed := &models.RecordOptions{Allow: []string{"100.64.0.0/10", "2400:cb00::/32"}}
+	for _, tc := range []struct {
+		o     *models.RecordOptions
+		value string
+		ok    bool
+	}{
+		{external, "1.1.1.1", true},
+		{external, "2606:4700::1111", true},
+		{external, "192.168.1.2", false},
+		{external, "10.1.2.3", false},
+		{external, "100.72.1.2", false},
+		{external, "fd00::1", false},
+		{external, "2001:db8::1", false},
+		{internal, "192.168.1.2", true},
+		{allowed, "100.72.1.2", true},
+		{allowed, "2400:cb00::1", true},
+		{allowed, "1.1.1.1", false},
+	} {
+		err := checkAddress(tc.o, tc.value)
+		if (err == nil) != tc.ok {
+			t.Errorf("%s with %+v: got %v", tc.value, *tc.o, err)
+		}
+	}
+
+	if err := checkAddress(external, "100.64.0.1"); err == nil || !strings.Contains(err.Error(), "ipv6") {
+		t.Errorf("CGNAT address without an IPv6 hint: %v", err)
+	}
+
+	allowBogons = true
+	defer func() { allowBogons = false }()
+	if err := checkAddress(external, "192.168.1.2"); err != nil {
+		t.Errorf("-allowBogons: %v", err)
+	}
+}
@@ -0,0 +1,286 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"net/netip"
+	"net/url"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/missdeer/ddnsclient/models"
+)
+
+// virtualInterfaces are skipped unless an interface filter names them.
+var virtualInterfaces = []string{"docker*", "br-*", "veth*", "virbr*", "vmnet*", "tun*", "tap*", "wg*", "utun*", "tailscale*", "zt*"}
+
+func init() {
+	registerIPSource("iface", newInterfaceSource)
+}
+
+// interfaceAddr is an address assigned to a local interface.
+type interfaceAddr struct {
+	name  string
+	index int
+	addr  netip.Addr
+	flags addrFlags
+}
+
+type interfaceAddrKey struct {
+	index int
+	addr  netip.Addr
+}
+
+// addrFlags are the states of an IPv6 address the system reports, see ipv6AddrFlags.
+type addrFlags uint8
+
+const (
+	// addrTemporary is a privacy address (RFC 8981) which changes every day or so
+	addrTemporary addrFlags = 1 << iota
+	// addrDeprecated shouldn't be used for new connections any more
+	addrDeprecated
+	// addrTentative hasn't passed duplicate address detection
+	addrTentative
+	// addrStable is configured statically or a stable privacy address (RFC 7217)
+	addrStable
+)
+
+// ulaPrefix is the range of unique local IPv6 addresses (RFC 4193).
+var ulaPrefix = netip.MustParsePrefix("fc00::/7")
+
+// addrRank orders addresses of the same interface filter, lower is better,
+// or returns false for addresses which shouldn't be published at all. IPv6
+// global addresses beat unique local ones, and stable or EUI-64 addresses
+// beat the others; temporary, deprecated and tentative ones are skipped.
+func addrRank(a interfaceAddr) (int, bool) {
+	if a.addr.IsLoopback() || a.addr.IsLinkLocalUnicast() || a.addr.IsMulticast() || a.addr.IsUnspecified() {
+		return 0, false
+	}
+	if a.addr.Is4() {
+		return 0, true
+	}
+	if a.flags&(addrTemporary|addrDeprecated|addrTentative) != 0 {
+		return 0, false
+	}
+	rank := 0
+	if ulaPrefix.Contains(a.addr) {
+		rank += 2
+	}
+	if b := a.addr.As16(); a.flags&addrStable == 0 && (b[11] != 0xff || b[12] != 0xfe) {
+		rank++
+	}
+	return rank, true
+}
+
+// interfaceSelector picks a local address matching an interface filter.
+type interfaceSelector struct {
+	names   []string
+	include []netip.Prefix
+	exclude []netip.Prefix
+}
+
+func newInterfaceSelector(f *models.InterfaceFilter) (*interfaceSelector, error) {
+	s := &interfaceSelector{}
+	if f == nil {
+		return s, nil
+	}
+	for _, name := range f.Names {
+		if _, err := path.Match(name, ""); err != nil {
+			return nil, fmt.Errorf("invalid interface name pattern %q: %w", name, err)
+		}
+		s.names = append(s.names, name)
+	}
+	for _, cidr := range f.Include {
+		prefix, err := netip.ParsePrefix(cidr)
+		if err != nil {
+			return nil, err
+		}
+		s.include = append(s.include, prefix.Masked())
+	}
+	for _, cidr := range f.Exclude {
+		prefix, err := netip.ParsePrefix(cidr)
+		if err != nil {
+			return nil, err
+		}
+		s.exclude = append(s.exclude, prefix.Masked())
+	}
+	return s, nil
+}
+
+// String describes the filter, it's also used to tell filters apart.
+func (s *interfaceSelector) String() string {
+	var parts []string
+	if len(s.names) != 0 {
+		parts = append(parts, "names="+strings.Join(s.names, ","))
+	}
+	for _, prefix := range s.include {
+		parts = append(parts, "include="+prefix.String())
+	}
+	for _, prefix := range s.exclude {
+		parts = append(parts, "exclude="+prefix.String())
+	}
+	if len(parts) == 0 {
+		return "default"
+	}
+	return strings.Join(parts, " ")
+}
+
+// rank returns the position of addr among the preferences of the filter,
+// lower is better, or false if the filter rejects it.
+func (s *interfaceSelector) rank(a interfaceAddr) (nameRank int, prefixRank int, ok bool) {
+	if len(s.names) == 0 {
+		if matchAny(virtualInterfaces, a.name) >= 0 {
+			return 0, 0, false
+		}
+	} else if nameRank = matchAny(s.names, a.name); nameRank < 0 {
+		return 0, 0, false
+	}
+	for _, prefix := range s.exclude {
+		if prefix.Contains(a.addr) {
+			return 0, 0, false
+		}
+	}
+	if len(s.include) != 0 {
+		prefixRank = -1
+		for i, prefix := range s.include {
+			if prefix.Contains(a.addr) {
+				prefixRank = i
+				break
+			}
+		}
+		if prefixRank < 0 {
+			return 0, 0, false
+		}
+	}
+	return nameRank, prefixRank, true
+}
+
+// choose returns the best address of the family among addrs: the earliest
+// matching name pattern wins, then the earliest matching include prefix, then
+// addrRank, the lowest interface index and finally the lowest address, so the
+// choice doesn't depend on the order the system lists addresses in.
+func (s *interfaceSelector) choose(addrs []interfaceAddr, ipv4 bool) (interfaceAddr, error) {
+	var best interfaceAddr
+	var bestKey []int
+	for _, a := range addrs {
+		if a.addr.Is4() != ipv4 {
+			continue
+		}
+		rank, ok := addrRank(a)
+		if !ok {
+			continue
+		}
+		nameRank, prefixRank, ok := s.rank(a)
+		if !ok {
+			continue
+		}
+		key := []int{nameRank, prefixRank, rank, a.index}
+		if bestKey != nil {
+			if c := slices.Compare(key, bestKey); c > 0 || (c == 0 && !a.addr.Less(best.addr)) {
+				continue
+			}
+		}
+		best, bestKey = a, key
+	}
+	if bestKey == nil {
+		return interfaceAddr{}, fmt.Errorf("no local %s address matches interface filter %s", familyName(ipv4), s)
+	}
+	return best, nil
+}
+
+// selectAddr picks the address of the family from the interfaces that are up.
+func (s *interfaceSelector) selectAddr(ipv4 bool) (netip.Addr, error) {
+	addrs, err := localInterfaceAddrs()
+	if err != nil {
+		return netip.Addr{}, err
+	}
+	a, err := s.choose(addrs, ipv4)
+	return a.addr, err
+}
+
+func matchAny(patterns []string, name string) int {
+	for i, pattern := range patterns {
+		if ok, _ := path.Match(pattern, name); ok {
+			return i
+		}
+	}
+	return -1
+}
+
+// localInterfaceAddrs lists the addresses of the interfaces that are up.
+func localInterfaceAddrs() ([]interfaceAddr, error) {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil, err
+	}
+	flags, err := ipv6AddrFlags()
+	if err != nil {
+		log.Println("reading IPv6 address flags failed, temporary addresses may be chosen:", err)
+	}
+	var addrs []interfaceAddr
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagUp == 0 {
+			continue
+		}
+		ifaceAddrs, err := iface.Addrs()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", iface.Name, err)
+		}
+		for _, address := range ifaceAddrs {
+			ipnet, ok := address.(*net.IPNet)
+			if !ok {
+				continue
+			}
+			addr, ok := netip.AddrFromSlice(ipnet.IP)
+			if !ok {
+				continue
+			}
+			addr = addr.Unmap()
+			addrs = append(addrs, interfaceAddr{
+				name:  iface.Name,
+				index: iface.Index,
+				addr:  addr,
+				flags: flags[interfaceAddrKey{iface.Index, addr}],
+			})
+		}
+	}
+	return addrs, nil
+}
+
+// interfaceSource reads the address of a local interface, for hosts which
+// own a public address, e.g. "iface:ppp0" or "iface:eth*?exclude=10.0.0.0/8".
+// The filter works like the interface option of internal records.
+type interfaceSource struct {
+	spec     string
+	selector *interfaceSelector
+}
+
+func newInterfaceSource(spec string) (ipSource, error) {
+	u, err := url.Parse(spec)
+	if err != nil {
+		return nil, err
+	}
+	f := &models.InterfaceFilter{
+		Include: u.Query()["include"],
+		Exclude: u.Query()["exclude"],
+	}
+	if name := u.Opaque + u.Host; len(name) != 0 {
+		f.Names = []string{name}
+	}
+	selector, err := newInterfaceSelector(f)
+	if err != nil {
+		return nil, err
+	}
+	return &interfaceSource{spec: spec, selector: selector}, nil
+}
+
+func (s *interfaceSource) Name() string {
+	return s.spec
+}
+
+func (s *interfaceSource) Detect(ctx context.Context, ipv4 bool) (netip.Addr, error) {
+	return s.selector.selectAddr(ipv4)
+}
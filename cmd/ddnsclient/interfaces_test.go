@@ -0,0 +1,126 @@
+package main
+
+import (
+	"net/netip"
+	"testing"
+
+	"github.com/missdeer/ddnsclient/models"
+)
+
+func TestInterfaceSelector(t *testing.T) {
+	addrs := []interfaceAddr{
+		{"docker0", 3, netip.MustParseAddr("172.17.0.1"), 0},
+		{"wg0", 5, netip.MustParseAddr("10.8.0.2"), 0},
+		{"eth1", 4, netip.MustParseAddr("192.168.2.10"), 0},
+		{"eth0", 2, netip.MustParseAddr("192.168.1.20"), 0},
+		{"eth0", 2, netip.MustParseAddr("192.168.1.10"), 0},
+		{"eth0", 2, netip.MustParseAddr("fe80::1"), 0},
+		{"eth0", 2, netip.MustParseAddr("2001:db8::10"), 0},
+		{"lo", 1, netip.MustParseAddr("127.0.0.1"), 0},
+	}
+	for _, tc := range []struct {
+		filter *models.InterfaceFilter
+		ipv4   bool
+		want   string
+	}{
+		// lowest interface index, then lowest address, virtual interfaces skipped
+		{nil, true, "192.168.1.10"},
+		{nil, false, "2001:db8::10"},
+		{&models.InterfaceFilter{Names: []string{"eth1", "eth*"}}, true, "192.168.2.10"},
+		{&models.InterfaceFilter{Names: []string{"wg*"}}, true, "10.8.0.2"},
+		{&models.InterfaceFilter{Include: []string{"192.168.2.0/24", "192.168.0.0/16"}}, true, "192.168.2.10"},
+		{&models.InterfaceFilter{Exclude: []string{"192.168.1.10/32"}}, true, "192.168.1.20"},
+		{&models.InterfaceFilter{Names: []string{"eth*"}, Include: []string{"10.0.0.0/8"}}, true, ""},
+		{&models.InterfaceFilter{Names: []string{"eth1"}}, false, ""},
+	} {
+		selector, err := newInterfaceSelector(tc.filter)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// the order addresses are listed in must not matter
+		for _, list := range [][]interfaceAddr{addrs, reversed(addrs)} {
+			got, err := selector.choose(list, tc.ipv4)
+			if len(tc.want) == 0 {
+				if err == nil {
+					t.Errorf("%s: got %s, want an error", selector, got.addr)
+				}
+				continue
+			}
+			if err != nil {
+				t.Errorf("%s: %v", selector, err)
+			} else if got.addr.String() != tc.want {
+				t.Errorf("%s: got %s, want %s", selector, got.addr, tc.want)
+			}
+		}
+	}
+}
+
+func TestInterfaceSelectorIPv6(t *testing.T) {
+	selector, _ := newInterfaceSelector(nil)
+	for _, tc := range []struct {
+		addrs []interfaceAddr
+		want  string
+	}{
+		// temporary and deprecated addresses are never chosen
+		{[]interfaceAddr{
+			{"eth0", 2, netip.MustParseAddr("2001:db8::1234:5678"), addrTemporary},
+			{"eth0", 2, netip.MustParseAddr("2001:db8::1"), addrDeprecated},
+			{"eth0", 2, netip.MustParseAddr("2001:db8::2:3"), 0},
+		}, "2001:db8::2:3"},
+		// stable and EUI-64 addresses beat other global ones
+		{[]interfaceAddr{
+			{"eth0", 2, netip.MustParseAddr("2001:db8::1"), 0},
+			{"eth0", 2, netip.MustParseAddr("2001:db8::9:9"), addrStable},
+		}, "2001:db8::9:9"},
+		{[]interfaceAddr{
+			{"eth0", 2, netip.MustParseAddr("2001:db8::1"), 0},
+			{"eth0", 2, netip.MustParseAddr("2001:db8::211:22ff:fe33:4455"), 0},
+		}, "2001:db8::211:22ff:fe33:4455"},
+		// global beats unique local, which beats nothing
+		{[]interfaceAddr{
+			{"eth0", 2, netip.MustParseAddr("fd00::1"), addrStable},
+			{"eth0", 2, netip.MustParseAddr("2001:db8::1"), 0},
+		}, "2001:db8::1"},
+		{[]interfaceAddr{
+			{"eth0", 2, netip.MustParseAddr("fe80::1"), addrStable},
+			{"eth0", 2, netip.MustParseAddr("fd00::1"), 0},
+		}, "fd00::1"},
+		{[]interfaceAddr{
+			{"eth0", 2, netip.MustParseAddr("fe80::1"), addrStable},
+			{"eth0", 2, netip.MustParseAddr("2001:db8::1"), addrTentative},
+		}, ""},
+	} {
+		got, err := selector.choose(tc.addrs, false)
+		if len(tc.want) == 0 {
+			if err == nil {
+				t.Errorf("got %s, want an error", got.addr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Error(err)
+		} else if got.addr.String() != tc.want {
+			t.Errorf("got %s, want %s", got.addr, tc.want)
+		}
+	}
+}
+
+func TestInterfaceSelectorInvalid(t *testing.T) {
+	for _, f := range []*models.InterfaceFilter{
+		{Names: []string{"eth["}},
+		{Include: []string{"192.168.1.0"}},
+		{Exclude: []string{"not a prefix"}},
+	} {
+		if _, err := newInterfaceSelector(f); err == nil {
+			t.Errorf("%+v accepted", *f)
+		}
+	}
+}
+
+func reversed(addrs []interfaceAddr) []interfaceAddr {
+	r := make([]interfaceAddr, 0, len(addrs))
+	for i := len(addrs) - 1; i >= 0; i-- {
+		r = append(r, addrs[i])
+	}
+	return r
+}
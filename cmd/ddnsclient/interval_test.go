@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"net/netip"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/missdeer/ddnsclient/models"
+)
+
+// namedProvider is a fakeProvider with its own name.
+type namedProvider struct {
+	*fakeProvider
+	name string
+}
+
+func (p *namedProvider) Name() string { return p.name }
+
+func newNamedProvider(name string, o models.RecordOptions) *namedProvider {
+	return &namedProvider{fakeProvider: &fakeProvider{options: o}, name: name}
+}
+
+// countingSource reports the address in its spec and counts how often it's asked.
+type countingSource struct {
+	spec  string
+	calls *atomic.Int32
+}
+
+var countingCalls = map[string]*atomic.Int32{"counting:198.51.100.1": {}, "counting:198.51.100.2": {}}
+
+func init() {
+	registerIPSource("counting", func(spec string) (ipSource, error) {
+		return &countingSource{spec: spec, calls: countingCalls[spec]}, nil
+	})
+}
+
+func (s *countingSource) Name() string { return s.spec }
+
+func (s *countingSource) Detect(ctx context.Context, ipv4 bool) (netip.Addr, error) {
+	s.calls.Add(1)
+	return netip.ParseAddr(strings.TrimPrefix(s.spec, "counting:"))
+}
+
+func TestDetectIPsBySource(t *testing.T) {
+	for _, calls := range countingCalls {
+		calls.Store(0)
+	}
+	a := models.RecordOptions{Stack: "ipv4", Sources: "counting:198.51.100.1"}
+	b := models.RecordOptions{Stack: "ipv4", Sources: "counting:198.51.100.2"}
+	setting := &Setting{Providers: []Provider{
+		newNamedProvider("fake:a1", a),
+		newNamedProvider("fake:a2", a),
+		newNamedProvider("fake:b", b),
+	}}
+	fallback := newDetector([]ipSource{&fixedSource{name: "fallback", addr: "203.0.113.1"}}, 1)
+	clear(currentIPs)
+	defer clear(currentIPs)
+	detectIPs(context.Background(), setting, newDetectorSet(fallback, 1))
+
+	for spec, calls := range countingCalls {
+		if n := calls.Load(); n != 1 {
+			t.Errorf("%s asked %d times, want once", spec, n)
+		}
+	}
+	for i, want := range []string{"198.51.100.1", "198.51.100.1", "198.51.100.2"} {
+		p := setting.Providers[i]
+		if got := recordValues(p.Options())["A"]; got != want {
+			t.Errorf("%s got %s, want %s", p.Name(), got, want)
+		}
+	}
+}
+
+func TestSettingDue(t *testing.T) {
+	defer func(d time.Duration) { checkInterval = d }(checkInterval)
+	checkInterval = time.Minute
+	hourly := newNamedProvider("fake:hourly", models.RecordOptions{Interval: "1h"})
+	fast := newNamedProvider("fake:fast", models.RecordOptions{Interval: "30s"})
+	standard := newNamedProvider("fake:standard", models.RecordOptions{})
+	setting := &Setting{Providers: []Provider{hourly, fast, standard}}
+	if tick := setting.tick(); tick != 30*time.Second {
+		t.Fatalf("got tick %v, want 30s", tick)
+	}
+
+	start := time.Now()
+	for _, p := range setting.Providers {
+		lastChecked[p.Name()] = start
+	}
+	defer clear(lastChecked)
+	for _, tc := range []struct {
+		after time.Duration
+		want  []string
+	}{
+		{10 * time.Second, nil},
+		// ticks arriving a bit early still count
+		{29 * time.Second, []string{"fake:fast"}},
+		{59 * time.Second, []string{"fake:fast", "fake:standard"}},
+		{time.Hour, []string{"fake:hourly", "fake:fast", "fake:standard"}},
+	} {
+		var got []string
+		for _, p := range setting.due(start.Add(tc.after)).Providers {
+			got = append(got, p.Name())
+		}
+		if strings.Join(got, ",") != strings.Join(tc.want, ",") {
+			t.Errorf("after %v: got %v, want %v", tc.after, got, tc.want)
+		}
+	}
+}
+
+func TestUpdateDDNSSharedAddressIntervals(t *testing.T) {
+	o := models.RecordOptions{Stack: "ipv4", Allow: []string{"203.0.113.0/24"}}
+	hourly, fast := o, o
+	hourly.Interval, fast.Interval = "1h", "1m"
+	hourlyProvider := newNamedProvider("fake:hourly", hourly)
+	fastProvider := newNamedProvider("fake:fast", fast)
+	source := &fixedSource{name: "fixed", addr: "203.0.113.1"}
+	external := newDetectorSet(newDetector([]ipSource{source}, 1), 1)
+	sched := newTestScheduler(t, retryPolicy{maxAttempts: 1})
+	clear(currentIPs)
+	clear(lastValues)
+	defer clear(currentIPs)
+	defer clear(lastValues)
+	defer clear(lastChecked)
+
+	updateDDNS(context.Background(), &Setting{Providers: []Provider{hourlyProvider, fastProvider}}, external, sched, false)
+	sched.wait()
+	// the fast record notices the new address first, the hourly one still
+	// has to publish it when it's due
+	source.addr = "203.0.113.2"
+	updateDDNS(context.Background(), &Setting{Providers: []Provider{fastProvider}}, external, sched, false)
+	sched.wait()
+	updateDDNS(context.Background(), &Setting{Providers: []Provider{hourlyProvider}}, external, sched, false)
+	sched.wait()
+
+	for _, p := range []*namedProvider{hourlyProvider, fastProvider} {
+		if got := p.published(); strings.Join(got, ",") != "203.0.113.1,203.0.113.2" {
+			t.Errorf("%s published %v, want both addresses", p.Name(), got)
+		}
+	}
+}
+
+func TestUpdateDDNSCancelled(t *testing.T) {
+	p := newNamedProvider("fake:cancelled", models.RecordOptions{Stack: "ipv4", Allow: []string{"203.0.113.0/24"}})
+	external := newDetectorSet(newDetector([]ipSource{&blockingSource{}}, 1), 1)
+	sched := newTestScheduler(t, retryPolicy{maxAttempts: 1})
+	clear(currentIPs)
+	clear(lastValues)
+	defer clear(currentIPs)
+	defer clear(lastValues)
+	defer clear(lastChecked)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(10*time.Millisecond, cancel)
+	start := time.Now()
+	updateDDNS(ctx, &Setting{Providers: []Provider{p}}, external, sched, false)
+	sched.wait()
+	if d := time.Since(start); d > requestTimeout/2 {
+		t.Errorf("cancelled detection took %v", d)
+	}
+	if got := p.published(); len(got) != 0 {
+		t.Errorf("published %v after the detection was cancelled", got)
+	}
+}
+
+// blockingSource answers when its context is done.
+type blockingSource struct{}
+
+func (s *blockingSource) Name() string { return "blocking" }
+
+func (s *blockingSource) Detect(ctx context.Context, ipv4 bool) (netip.Addr, error) {
+	<-ctx.Done()
+	return netip.Addr{}, ctx.Err()
+}
@@ -0,0 +1,437 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"maps"
+	"net"
+	"net/netip"
+	"os"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/missdeer/ddnsclient/models"
+)
+
+// lanHostsKey is the state store key suffix listing the hosts published for
+// a LAN item, so their records can be removed after a restart too.
+const lanHostsKey = "/LAN"
+
+// lanHosts publishes the hosts of the local network for the items with lan options.
+var lanHosts = newLANPublisher()
+
+// lanHost is a host of the local network found in a lease file.
+type lanHost struct {
+	name    string
+	mac     string
+	ipv4    netip.Addr
+	ipv6    netip.Addr
+	expires time.Time
+}
+
+// lanPublisher keeps the providers of the hosts published for every LAN item.
+type lanPublisher struct {
+	published map[string]map[string]Provider
+}
+
+func newLANPublisher() *lanPublisher {
+	return &lanPublisher{published: make(map[string]map[string]Provider)}
+}
+
+// update publishes the current hosts of the LAN item and removes the records
+// of hosts which are gone. Nothing is removed if the hosts can't be read.
+func (l *lanPublisher) update(setting *Setting, item Provider, sched *scheduler) {
+	o := item.Options()
+	hosts, err := discoverLANHosts(o.LAN, time.Now())
+	if err != nil {
+		log.Printf("%s: reading LAN hosts failed: %v\n", item.Name(), err)
+		return
+	}
+	raw := setting.items[item.Name()]
+	current, ok := l.published[item.Name()]
+	if !ok {
+		current = l.restore(raw, item, sched.state)
+	}
+
+	next := make(map[string]Provider)
+	for _, h := range hosts {
+		if !matchLANHost(o.LAN, h) {
+			continue
+		}
+		p := current[h.name]
+		if p == nil {
+			if p, err = deriveLANProvider(raw, item, h.name); err != nil {
+				log.Printf("%s: %v\n", item.Name(), err)
+				continue
+			}
+			log.Printf("LAN host %s found, publishing %s\n", h.name, p.Name())
+		}
+		next[h.name] = p
+		values := make(map[string]string)
+		stack := recordStack(p.Options())
+		if h.ipv4.IsValid() && stackHas(stack, true) {
+			values["A"] = h.ipv4.String()
+		}
+		if h.ipv6.IsValid() && stackHas(stack, false) {
+			values["AAAA"] = h.ipv6.String()
+		}
+		values = guardValues(p, values)
+		for recordType, value := range values {
+			if st, ok := sched.state.get(recordKey(p, recordType)); ok && st.published(value) {
+				delete(values, recordType)
+			}
+		}
+		if len(values) != 0 {
+			sched.submit(p, values, false)
+		}
+	}
+	for name, p := range current {
+		if _, ok := next[name]; !ok {
+			log.Printf("LAN host %s is gone, removing %s\n", name, p.Name())
+			sched.remove(p, []string{"A", "AAAA"})
+		}
+	}
+	l.published[item.Name()] = next
+
+	names := slices.Sorted(maps.Keys(next))
+	if st, _ := sched.state.get(item.Name() + lanHostsKey); st.Value != strings.Join(names, ",") {
+		st = recordState{Value: strings.Join(names, ","), UpdatedAt: time.Now(), Result: resultGood}
+		if err = sched.state.set(item.Name()+lanHostsKey, st); err != nil {
+			log.Println("saving state failed:", err)
+		}
+	}
+}
+
+// forget drops the providers of the hosts of a changed or removed item, the
+// hosts of a changed item are restored from the state store by the next update.
+func (l *lanPublisher) forget(item string) {
+	delete(l.published, item)
+}
+
+// removeAll removes the records of all hosts published for item, whose
+// JSON is raw, when the item is removed from the configuration.
+func (l *lanPublisher) removeAll(item Provider, raw string, sched *scheduler) {
+	published, ok := l.published[item.Name()]
+	if !ok {
+		published = l.restore(raw, item, sched.state)
+	}
+	for _, name := range slices.Sorted(maps.Keys(published)) {
+		p := published[name]
+		log.Printf("%s removed, removing %s\n", item.Name(), p.Name())
+		sched.remove(p, []string{"A", "AAAA"})
+	}
+	delete(l.published, item.Name())
+}
+
+// restore rebuilds the providers of the hosts published for item before a restart.
+func (l *lanPublisher) restore(raw string, item Provider, state *stateStore) map[string]Provider {
+	published := make(map[string]Provider)
+	st, ok := state.get(item.Name() + lanHostsKey)
+	if !ok || len(st.Value) == 0 {
+		return published
+	}
+	for _, name := range strings.Split(st.Value, ",") {
+		p, err := deriveLANProvider(raw, item, name)
+		if err != nil {
+			log.Printf("%s: %v\n", item.Name(), err)
+			continue
+		}
+		published[name] = p
+	}
+	return published
+}
+
+// deriveLANProvider builds the provider of host from raw, the JSON of the
+// LAN item, with host prepended to its sub_domain. The host's addresses are
+// local so it's an internal record.
+func deriveLANProvider(raw string, item Provider, host string) (Provider, error) {
+	section, _, _ := strings.Cut(item.Name(), ":")
+	factory, ok := providerFactories[section]
+	if !ok {
+		return nil, fmt.Errorf("unknown provider section %q", section)
+	}
+	var fields map[string]any
+	if err := json.Unmarshal([]byte(raw), &fields); err != nil {
+		return nil, err
+	}
+	sub, ok := fields["sub_domain"].(string)
+	if !ok {
+		return nil, errors.New("lan needs an item with a sub_domain")
+	}
+	if len(sub) == 0 || sub == "@" {
+		fields["sub_domain"] = host
+	} else {
+		fields["sub_domain"] = host + "." + sub
+	}
+	for key := range fields {
+		if strings.EqualFold(key, "lan") || strings.EqualFold(key, "internal") {
+			delete(fields, key)
+		}
+	}
+	fields["Internal"] = true
+	b, err := json.Marshal(fields)
+	if err != nil {
+		return nil, err
+	}
+	p, err := factory(b)
+	if err != nil {
+		return nil, err
+	}
+	if err = p.Validate(); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// matchLANHost reports whether h passes the host name and MAC address filters.
+func matchLANHost(o *models.LANOptions, h lanHost) bool {
+	if len(o.Hosts) == 0 && len(o.MACs) == 0 {
+		return true
+	}
+	if matchAny(o.Hosts, h.name) >= 0 {
+		return true
+	}
+	return len(h.mac) != 0 && matchAny(o.MACs, h.mac) >= 0
+}
+
+// validateLAN checks the lan options of o.
+func validateLAN(o *models.LANOptions) error {
+	if len(o.Leases) == 0 {
+		return errors.New("lan needs lease files to read host names from")
+	}
+	for _, pattern := range append(slices.Clone(o.Hosts), o.MACs...) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid LAN host pattern %q: %w", pattern, err)
+		}
+	}
+	return nil
+}
+
+// discoverLANHosts reads the hosts with leases which haven't expired at now
+// and, if enabled, adds the IPv6 addresses of their MAC addresses in the
+// neighbor table.
+func discoverLANHosts(o *models.LANOptions, now time.Time) ([]lanHost, error) {
+	byName := make(map[string]*lanHost)
+	for _, file := range o.Leases {
+		leases, err := readLeases(file)
+		if err != nil {
+			return nil, err
+		}
+		for _, lease := range leases {
+			if !lease.expires.IsZero() && lease.expires.Before(now) {
+				continue
+			}
+			if lease.name = lanHostName(lease.name); len(lease.name) == 0 {
+				continue
+			}
+			h := byName[lease.name]
+			if h == nil {
+				h = &lanHost{name: lease.name}
+				byName[lease.name] = h
+			}
+			if len(lease.mac) != 0 {
+				h.mac = lease.mac
+			}
+			if lease.ipv4.IsValid() {
+				h.ipv4 = lease.ipv4
+			}
+			if lease.ipv6.IsValid() {
+				h.ipv6 = lease.ipv6
+			}
+		}
+	}
+	if o.Neighbors {
+		neighbors, err := neighborTable()
+		if err != nil {
+			return nil, err
+		}
+		for _, h := range byName {
+			if len(h.mac) != 0 && !h.ipv6.IsValid() {
+				h.ipv6 = neighborIPv6(h.mac, neighbors[h.mac])
+			}
+		}
+	}
+
+	hosts := make([]lanHost, 0, len(byName))
+	for _, name := range slices.Sorted(maps.Keys(byName)) {
+		hosts = append(hosts, *byName[name])
+	}
+	return hosts, nil
+}
+
+// neighborIPv6 picks the global IPv6 address of a host out of the neighbor
+// table entries of its MAC address, its EUI-64 address if it has one.
+func neighborIPv6(mac string, addrs []netip.Addr) netip.Addr {
+	var best netip.Addr
+	iid, err := eui64(mac)
+	for _, addr := range addrs {
+		if !addr.Is6() || !addr.IsGlobalUnicast() || addr.IsLinkLocalUnicast() || ulaPrefix.Contains(addr) {
+			continue
+		}
+		if err == nil && withSuffix(addr, 64, iid) == addr {
+			return addr
+		}
+		if !best.IsValid() || addr.Less(best) {
+			best = addr
+		}
+	}
+	return best
+}
+
+// lanHostName turns a DHCP client name into a DNS label, or returns an empty
+// string if nothing usable is left.
+func lanHostName(name string) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(name) {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9', r == '-':
+			b.WriteRune(r)
+		case r == '_', r == ' ':
+			b.WriteByte('-')
+		}
+	}
+	label := strings.Trim(b.String(), "-")
+	if len(label) > 63 {
+		label = strings.TrimRight(label[:63], "-")
+	}
+	return label
+}
+
+// readLeases reads a dnsmasq or an ISC dhcpd lease file, told apart by
+// their syntax.
+func readLeases(file string) ([]lanHost, error) {
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	if strings.Contains(string(b), "lease ") && strings.Contains(string(b), "{") {
+		return parseISCLeases(string(b))
+	}
+	return parseDnsmasqLeases(string(b)), nil
+}
+
+// parseDnsmasqLeases parses dnsmasq leases, one per line:
+//
+//	<expiry> <MAC address or IAID> <address> <host name or *> <client ID or *>
+//
+// The expiry is a Unix time, 0 for an infinite lease.
+func parseDnsmasqLeases(s string) []lanHost {
+	var hosts []lanHost
+	scanner := bufio.NewScanner(strings.NewReader(s))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 4 {
+			continue
+		}
+		expiry, err := strconv.ParseInt(fields[0], 10, 64)
+		if err != nil {
+			// the "duid" line of DHCPv6 leases
+			continue
+		}
+		addr, err := netip.ParseAddr(fields[2])
+		if err != nil {
+			continue
+		}
+		h := lanHost{}
+		if fields[3] != "*" {
+			h.name = fields[3]
+		}
+		if expiry != 0 {
+			h.expires = time.Unix(expiry, 0)
+		}
+		if addr.Is4() {
+			h.ipv4 = addr
+			if mac, err := net.ParseMAC(fields[1]); err == nil {
+				h.mac = mac.String()
+			}
+		} else {
+			h.ipv6 = addr
+		}
+		hosts = append(hosts, h)
+	}
+	return hosts
+}
+
+// parseISCLeases parses the IPv4 leases of an ISC dhcpd lease file, later
+// entries of an address replace earlier ones and only active leases are
+// returned:
+//
+//	lease 192.168.1.10 {
+//	  ends 4 2026/10/15 22:00:00;
+//	  binding state active;
+//	  hardware ethernet 00:11:22:33:44:55;
+//	  client-hostname "nas";
+//	}
+func parseISCLeases(s string) ([]lanHost, error) {
+	leases := make(map[netip.Addr]lanHost)
+	active := make(map[netip.Addr]bool)
+	var current *lanHost
+	var currentActive bool
+	scanner := bufio.NewScanner(strings.NewReader(s))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "#") || len(line) == 0 {
+			continue
+		}
+		if current == nil {
+			if fields := strings.Fields(line); len(fields) == 3 && fields[0] == "lease" && fields[2] == "{" {
+				addr, err := netip.ParseAddr(fields[1])
+				if err != nil {
+					return nil, fmt.Errorf("invalid lease address %q", fields[1])
+				}
+				current, currentActive = &lanHost{ipv4: addr}, true
+			}
+			continue
+		}
+		if line == "}" {
+			leases[current.ipv4], active[current.ipv4] = *current, currentActive
+			current = nil
+			continue
+		}
+		// statements end with ";", dhcpd may add a comment after it
+		statement, _, _ := strings.Cut(line, ";")
+		fields := strings.Fields(statement)
+		switch {
+		case len(fields) >= 2 && fields[0] == "ends":
+			if fields[1] == "never" {
+				current.expires = time.Time{}
+			} else if fields[1] == "epoch" && len(fields) >= 3 {
+				epoch, err := strconv.ParseInt(fields[2], 10, 64)
+				if err != nil {
+					return nil, fmt.Errorf("invalid lease end %q", line)
+				}
+				current.expires = time.Unix(epoch, 0)
+			} else if len(fields) >= 4 {
+				t, err := time.Parse("2006/01/02 15:04:05", fields[2]+" "+fields[3])
+				if err != nil {
+					return nil, fmt.Errorf("invalid lease end %q", line)
+				}
+				current.expires = t
+			}
+		case len(fields) >= 3 && fields[0] == "binding" && fields[1] == "state":
+			currentActive = fields[2] == "active"
+		case len(fields) >= 3 && fields[0] == "hardware" && fields[1] == "ethernet":
+			if mac, err := net.ParseMAC(fields[2]); err == nil {
+				current.mac = mac.String()
+			}
+		case len(fields) >= 2 && fields[0] == "client-hostname":
+			current.name = strings.Trim(strings.Join(fields[1:], " "), `"`)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	var hosts []lanHost
+	for _, addr := range slices.SortedFunc(maps.Keys(leases), netip.Addr.Compare) {
+		if active[addr] {
+			hosts = append(hosts, leases[addr])
+		}
+	}
+	return hosts, nil
+}
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/missdeer/ddnsclient/models"
+)
+
+// lanFakeProvider is a fakeProvider of a configured domain which records
+// the records it's asked to remove.
+type lanFakeProvider struct {
+	*namedProvider
+	deleted []string
+}
+
+func (p *lanFakeProvider) Delete(ctx context.Context, recordType string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.deleted = append(p.deleted, recordType)
+	return nil
+}
+
+var lanFakes = struct {
+	sync.Mutex
+	providers map[string]*lanFakeProvider
+}{providers: make(map[string]*lanFakeProvider)}
+
+func init() {
+	registerProvider("lanfake", func(raw json.RawMessage) (Provider, error) {
+		var item struct {
+			Domain    string `json:"domain"`
+			SubDomain string `json:"sub_domain"`
+			models.RecordOptions
+		}
+		if err := json.Unmarshal(raw, &item); err != nil {
+			return nil, err
+		}
+		p := &lanFakeProvider{namedProvider: newNamedProvider("lanfake:"+item.SubDomain+"."+item.Domain, item.RecordOptions)}
+		lanFakes.Lock()
+		lanFakes.providers[p.Name()] = p
+		lanFakes.Unlock()
+		return p, nil
+	})
+}
+
+func TestParseDnsmasqLeases(t *testing.T) {
+	hosts := parseDnsmasqLeases(`1792000000 00:11:22:33:44:55 192.168.1.10 nas 01:00:11:22:33:44:55
+0 66:77:88:99:aa:bb 192.168.1.11 * *
+duid 00:01:00:01:2c:00:00:00:00:11:22:33:44:55
+1792000000 1234 2001:db8::10 nas 00:01:00:01
+`)
+	if len(hosts) != 3 {
+		t.Fatalf("parsed %d leases: %+v", len(hosts), hosts)
+	}
+	if h := hosts[0]; h.name != "nas" || h.mac != "00:11:22:33:44:55" || h.ipv4 != netip.MustParseAddr("192.168.1.10") || !h.expires.Equal(time.Unix(1792000000, 0)) {
+		t.Errorf("first lease is %+v", h)
+	}
+	if h := hosts[1]; h.name != "" || !h.expires.IsZero() {
+		t.Errorf("lease without a name or expiry is %+v", h)
+	}
+	if h := hosts[2]; h.ipv6 != netip.MustParseAddr("2001:db8::10") || h.mac != "" {
+		t.Errorf("DHCPv6 lease is %+v", h)
+	}
+}
+
+func TestParseISCLeases(t *testing.T) {
+	hosts, err := parseISCLeases(`# The format of this file is documented in the dhcpd.leases(5) manual page.
+lease 192.168.1.20 {
+  starts 4 2026/10/15 20:00:00;
+  ends 4 2026/10/15 22:00:00;
+  binding state active;
+  hardware ethernet 00:11:22:33:44:66;
+  client-hostname "Old Name";
+}
+lease 192.168.1.21 {
+  ends never;
+  binding state free;
+  hardware ethernet 00:11:22:33:44:77;
+  client-hostname "printer";
+}
+lease 192.168.1.20 {
+  ends epoch 1792000000; # 2026/10/16 ...
+  binding state active;
+  hardware ethernet 00:11:22:33:44:66;
+  client-hostname "laptop";
+}
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(hosts) != 1 {
+		t.Fatalf("parsed %+v, want only the active lease", hosts)
+	}
+	if h := hosts[0]; h.name != "laptop" || h.mac != "00:11:22:33:44:66" || h.ipv4 != netip.MustParseAddr("192.168.1.20") || !h.expires.Equal(time.Unix(1792000000, 0)) {
+		t.Errorf("lease is %+v", h)
+	}
+}
+
+func TestDiscoverLANHosts(t *testing.T) {
+	now := time.Unix(1790000000, 0)
+	file := filepath.Join(t.TempDir(), "dnsmasq.leases")
+	leases := `1792000000 00:11:22:33:44:55 192.168.1.10 NAS_Box *
+1792000000 1234 2001:db8::10 nas-box *
+1780000000 00:11:22:33:44:66 192.168.1.11 expired *
+0 00:11:22:33:44:77 192.168.1.12 * *
+`
+	if err := os.WriteFile(file, []byte(leases), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	hosts, err := discoverLANHosts(&models.LANOptions{Leases: []string{file}}, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := lanHost{name: "nas-box", mac: "00:11:22:33:44:55", ipv4: netip.MustParseAddr("192.168.1.10"), ipv6: netip.MustParseAddr("2001:db8::10")}
+	if len(hosts) != 1 || hosts[0] != want {
+		t.Errorf("discovered %+v, want %+v", hosts, want)
+	}
+}
+
+func TestMatchLANHost(t *testing.T) {
+	h := lanHost{name: "nas", mac: "00:11:22:33:44:55"}
+	tests := []struct {
+		o    models.LANOptions
+		want bool
+	}{
+		{models.LANOptions{}, true},
+		{models.LANOptions{Hosts: []string{"na*"}}, true},
+		{models.LANOptions{Hosts: []string{"laptop"}}, false},
+		{models.LANOptions{Hosts: []string{"laptop"}, MACs: []string{"00:11:22:*"}}, true},
+		{models.LANOptions{MACs: []string{"66:*"}}, false},
+	}
+	for _, tt := range tests {
+		if got := matchLANHost(&tt.o, h); got != tt.want {
+			t.Errorf("matchLANHost(%+v) = %v, want %v", tt.o, got, tt.want)
+		}
+	}
+}
+
+func TestNeighborIPv6(t *testing.T) {
+	addrs := []netip.Addr{
+		netip.MustParseAddr("fe80::211:22ff:fe33:4455"),
+		netip.MustParseAddr("2001:db8::1"),
+		netip.MustParseAddr("2001:db8::211:22ff:fe33:4455"),
+	}
+	if got := neighborIPv6("00:11:22:33:44:55", addrs); got != netip.MustParseAddr("2001:db8::211:22ff:fe33:4455") {
+		t.Errorf("neighborIPv6 = %v, want the EUI-64 address", got)
+	}
+	if got := neighborIPv6("00:11:22:33:44:66", addrs); got != netip.MustParseAddr("2001:db8::1") {
+		t.Errorf("neighborIPv6 = %v, want the global address", got)
+	}
+}
+
+func TestLANPublisher(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "dnsmasq.leases")
+	writeLeases := func(leases string) {
+		if err := os.WriteFile(file, []byte(leases), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeLeases(`0 00:11:22:33:44:55 192.168.1.10 nas *
+0 00:11:22:33:44:66 192.168.1.11 laptop *
+0 00:11:22:33:44:77 192.168.1.12 phone *
+`)
+	raw, _ := json.Marshal(map[string]any{
+		"domain":     "example.com",
+		"sub_domain": "lan",
+		"stack":      "ipv4",
+		"lan":        map[string]any{"leases": []string{file}, "hosts": []string{"nas", "laptop"}},
+	})
+	providers, items, err := newProviders(map[string]json.RawMessage{"lanfake": json.RawMessage(`[` + string(raw) + `]`)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	setting := &Setting{Providers: providers, items: items}
+	item := providers[0]
+	sched := newTestScheduler(t, retryPolicy{maxAttempts: 1})
+	l := newLANPublisher()
+
+	l.update(setting, item, sched)
+	sched.wait()
+	for name, want := range map[string]string{"lanfake:nas.lan.example.com": "192.168.1.10", "lanfake:laptop.lan.example.com": "192.168.1.11"} {
+		p := l.published[item.Name()][name[len("lanfake:"):len(name)-len(".lan.example.com")]]
+		if p == nil || p.Name() != name || !p.Options().Internal {
+			t.Fatalf("%s isn't published as an internal record: %v", name, p)
+		}
+		if got := p.(*lanFakeProvider).published(); !slices.Equal(got, []string{want}) {
+			t.Errorf("%s published %v, want %s", name, got, want)
+		}
+	}
+	if len(l.published[item.Name()]) != 2 {
+		t.Errorf("published %v, want only the matching hosts", l.published[item.Name()])
+	}
+
+	// the laptop's lease is gone, after a restart its record is still removed
+	writeLeases("0 00:11:22:33:44:55 192.168.1.10 nas *\n")
+	l = newLANPublisher()
+	l.update(setting, item, sched)
+	sched.wait()
+	lanFakes.Lock()
+	nas, laptop := lanFakes.providers["lanfake:nas.lan.example.com"], lanFakes.providers["lanfake:laptop.lan.example.com"]
+	lanFakes.Unlock()
+	if !slices.Equal(laptop.deleted, []string{"A", "AAAA"}) {
+		t.Errorf("laptop records deleted: %v", laptop.deleted)
+	}
+	if len(nas.deleted) != 0 || len(nas.published()) != 0 {
+		t.Errorf("nas record was touched: deleted %v, published %v", nas.deleted, nas.published())
+	}
+	if _, ok := sched.state.get("lanfake:laptop.lan.example.com/A"); ok {
+		t.Error("state of the removed record is kept")
+	}
+	if st, _ := sched.state.get(item.Name() + lanHostsKey); st.Value != "nas" {
+		t.Errorf("published hosts are %q, want nas", st.Value)
+	}
+}
+
+func TestLANItemRemoved(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "dnsmasq.leases")
+	if err := os.WriteFile(file, []byte("0 00:11:22:33:44:55 192.168.1.10 nas *\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	raw, _ := json.Marshal(map[string]any{
+		"domain":     "example.com",
+		"sub_domain": "home",
+		"stack":      "ipv4",
+		"lan":        map[string]any{"leases": []string{file}},
+	})
+	providers, items, err := newProviders(map[string]json.RawMessage{"lanfake": json.RawMessage(`[` + string(raw) + `]`)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	setting := &Setting{Providers: providers, items: items}
+	item := providers[0]
+	sched := newTestScheduler(t, retryPolicy{maxAttempts: 1})
+	lanHosts.update(setting, item, sched)
+	sched.wait()
+	// after a restart the hosts are only known from the state store
+	lanHosts.forget(item.Name())
+
+	applySetting(setting, &Setting{items: map[string]string{}}, sched, sched.state)
+	sched.wait()
+	lanFakes.Lock()
+	nas := lanFakes.providers["lanfake:nas.home.example.com"]
+	lanFakes.Unlock()
+	if !slices.Equal(nas.deleted, []string{"A", "AAAA"}) {
+		t.Errorf("nas records deleted: %v", nas.deleted)
+	}
+	for _, key := range []string{"lanfake:nas.home.example.com/A", item.Name() + lanHostsKey} {
+		if _, ok := sched.state.get(key); ok {
+			t.Errorf("state %s of the removed item is kept", key)
+		}
+	}
+	if _, ok := lanHosts.published[item.Name()]; ok {
+		t.Error("hosts of the removed item are kept")
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/netip"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+func init() {
+	registerIPSource("cmd", newCommandSource)
+	registerIPSource("file", newFileSource)
+}
+
+// commandSource runs a command which prints the address, e.g. a script
+// asking the modem: "cmd:/usr/local/bin/wan-ip ppp0". The requested family
+// is passed in DDNS_FAMILY as ipv4 or ipv6 and the bind of the record, if
+// any, in DDNS_BIND. The output may list one address per line and the first
+// one of the family is used.
+type commandSource struct {
+	spec string
+	args []string
+}
+
+func newCommandSource(spec string) (ipSource, error) {
+	args := strings.Fields(strings.TrimPrefix(spec, "cmd:"))
+	if len(args) == 0 {
+		return nil, fmt.Errorf("command source %q should look like cmd:/path/to/command args", spec)
+	}
+	return &commandSource{spec: spec, args: args}, nil
+}
+
+func (s *commandSource) Name() string {
+	return s.spec
+}
+
+func (s *commandSource) Detect(ctx context.Context, ipv4 bool) (netip.Addr, error) {
+	cmd := exec.CommandContext(ctx, s.args[0], s.args[1:]...)
+	cmd.Env = append(os.Environ(), "DDNS_FAMILY="+strings.ToLower(familyName(ipv4)))
+	if bind, _ := ctx.Value(bindKey{}).(string); len(bind) != 0 {
+		cmd.Env = append(cmd.Env, "DDNS_BIND="+bind)
+	}
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); len(msg) != 0 {
+			return netip.Addr{}, fmt.Errorf("%w: %s", err, msg)
+		}
+		return netip.Addr{}, err
+	}
+	return pickAddr(out, ipv4)
+}
+
+// fileSource reads the address from a file, e.g. one written by a pppd
+// ip-up hook: "file:/run/ddnsclient/wan-ip". Like the output of a command
+// source it may list one address per line.
+type fileSource struct {
+	spec string
+	path string
+}
+
+func newFileSource(spec string) (ipSource, error) {
+	path := strings.TrimPrefix(strings.TrimPrefix(spec, "file:"), "//")
+	if len(path) == 0 {
+		return nil, fmt.Errorf("file source %q should look like file:/path/to/file", spec)
+	}
+	return &fileSource{spec: spec, path: path}, nil
+}
+
+func (s *fileSource) Name() string {
+	return s.spec
+}
+
+func (s *fileSource) Detect(ctx context.Context, ipv4 bool) (netip.Addr, error) {
+	b, err := os.ReadFile(s.path)
+	if err != nil {
+		return netip.Addr{}, err
+	}
+	return pickAddr(b, ipv4)
+}
+
+// pickAddr returns the first line of b holding an address of the family.
+func pickAddr(b []byte, ipv4 bool) (netip.Addr, error) {
+	var lastErr error
+	for _, line := range bytes.Split(b, []byte("\n")) {
+		line = bytes.TrimSpace(line)
+		if len(line) == 0 {
+			continue
+		}
+		addr, err := parseAddr(string(line))
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		if addr.Is4() == ipv4 {
+			return addr, nil
+		}
+	}
+	if lastErr != nil {
+		return netip.Addr{}, lastErr
+	}
+	return netip.Addr{}, errors.New("no " + familyName(ipv4) + " address found")
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestFileSource(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "wan-ip")
+	if err := os.WriteFile(path, []byte("203.0.113.7\n2001:db8::7\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	src, err := newIPSource("file:" + path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		ipv4 bool
+		want string
+	}{
+		{true, "203.0.113.7"},
+		{false, "2001:db8::7"},
+	} {
+		addr, err := src.Detect(context.Background(), tc.ipv4)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if addr.String() != tc.want {
+			t.Errorf("got %s, want %s", addr, tc.want)
+		}
+	}
+
+	os.WriteFile(path, []byte("<html>not an address</html>\n"), 0o644)
+	if addr, err := src.Detect(context.Background(), true); err == nil {
+		t.Errorf("got %s from garbage", addr)
+	}
+}
+
+func TestCommandSource(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("needs a shell")
+	}
+	script := filepath.Join(t.TempDir(), "wan-ip")
+	body := "#!/bin/sh\nif [ \"$DDNS_FAMILY\" = ipv4 ]; then echo 198.51.100.$1; else echo 2001:db8::$1; fi\n"
+	if err := os.WriteFile(script, []byte(body), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	src, err := newIPSource("cmd:" + script + " 9")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if addr, err := src.Detect(context.Background(), true); err != nil || addr.String() != "198.51.100.9" {
+		t.Errorf("got %s, %v", addr, err)
+	}
+	if addr, err := src.Detect(context.Background(), false); err != nil || addr.String() != "2001:db8::9" {
+		t.Errorf("got %s, %v", addr, err)
+	}
+
+	failing, _ := newIPSource("cmd:" + filepath.Join(t.TempDir(), "missing"))
+	if addr, err := failing.Detect(context.Background(), true); err == nil {
+		t.Errorf("got %s from a missing command", addr)
+	}
+}
@@ -4,68 +4,227 @@ import (
 	"context"
 	"crypto/tls"
 	"encoding/json"
-	"errors"
 	"flag"
 	"fmt"
 	"io/ioutil"
 	"log"
+	"maps"
 	"net"
 	"net/http"
 	"net/url"
 	"os"
-	"regexp"
+	"os/signal"
+	"slices"
 	"strings"
 	"time"
 
 	"github.com/missdeer/ddnsclient/models"
 )
 
+// Setting is the parsed app.conf, every top level key is a provider section
+// registered by registerProvider.
 type Setting struct {
-	BasicAuthItems  []models.BasicAuthConfigurationItem  `json:"basic"`
-	DnspodItems     []models.DnspodConfigurationItem     `json:"dnspod"`
-	CloudflareItems []models.CloudflareConfigurationItem `json:"cloudflare"`
-	CloudXNSItems   []models.CloudXNSConfigurationItem   `json:"cloudxns"`
+	Providers []Provider
+	// items maps provider names to the JSON of their config item
+	items map[string]string
 }
 
-var (
-	insecureSkipVerify  bool
-	ifconfigURL         string
-	currentExternalIPv4 string
-	currentExternalIPv6 string
-	currentInternalIPv4 string
-	currentInternalIPv6 string
-	lastExternalIPv4    string
-	lastExternalIPv6    string
-	lastInternalIPv4    string
-	lastInternalIPv6    string
-	networkStack        string
-)
-
-func getCurrentInternalIPs(ipv4 bool) ([]string, error) {
-	var ips []string
-	addrs, err := net.InterfaceAddrs()
+func loadSetting(b []byte) (*Setting, error) {
+	sections := make(map[string]json.RawMessage)
+	if err := json.Unmarshal(b, &sections); err != nil {
+		return nil, err
+	}
+	providers, items, err := newProviders(sections)
 	if err != nil {
-		fmt.Println(err)
 		return nil, err
 	}
-	for _, address := range addrs {
-		if ipnet, ok := address.(*net.IPNet); ok && !ipnet.IP.IsLoopback() {
-			if (ipv4 && ipnet.IP.To4() != nil) || (!ipv4 && ipnet.IP.To16() != nil) {
-				ips = append(ips, ipnet.IP.String())
+	return &Setting{Providers: providers, items: items}, nil
+}
+
+// recordStack returns the network stack of the record, falling back to the -stack flag.
+func recordStack(o *models.RecordOptions) string {
+	if len(o.Stack) != 0 {
+		return o.Stack
+	}
+	return networkStack
+}
+
+// stackHas reports whether the network stack includes records of the family.
+func stackHas(stack string, ipv4 bool) bool {
+	if ipv4 {
+		return stack == "ipv4" || stack == "dual"
+	}
+	return stack == "ipv6" || stack == "dual"
+}
+
+// recordValues maps the record types managed by the item to the current
+// address of the matching family, families without an address are left out.
+func recordValues(o *models.RecordOptions) map[string]string {
+	values := make(map[string]string)
+	stack := recordStack(o)
+	if ip := currentIPs[addressKey(o, true)]; stackHas(stack, true) && len(ip) != 0 {
+		values["A"] = ip
+	}
+	if ip := currentIPs[addressKey(o, false)]; stackHas(stack, false) && len(ip) != 0 {
+		values["AAAA"] = ip
+	}
+	return values
+}
+
+// addressKey identifies the address published by a record, records with the
+// same key share it: internal records with the same interface filter, LAN
+// host records with the same suffix and external records detected by the
+// same sources through the same local address or interface.
+func addressKey(o *models.RecordOptions, ipv4 bool) string {
+	key := familyName(ipv4)
+	if suffix, bits, ok, _ := hostSuffix(o); ok && !ipv4 {
+		key += fmt.Sprintf(" host %s/%d", suffix, bits)
+		if selector, err := newInterfaceSelector(o.Interface); err == nil {
+			key += " interface " + selector.String()
+		}
+		return key
+	}
+	if o.Internal {
+		if selector, err := newInterfaceSelector(o.Interface); err == nil {
+			key += " interface " + selector.String()
+		}
+		return key
+	}
+	if len(o.Sources) != 0 {
+		key += " from " + o.Sources
+	}
+	if o.Quorum != 0 {
+		key += fmt.Sprintf(" quorum %d", o.Quorum)
+	}
+	if len(o.Bind) != 0 {
+		key += " via " + o.Bind
+	}
+	return key
+}
+
+// recordInterval returns how often the record is checked, falling back to the -interval flag.
+func recordInterval(o *models.RecordOptions) time.Duration {
+	if d, err := time.ParseDuration(o.Interval); len(o.Interval) != 0 && err == nil {
+		return d
+	}
+	return checkInterval
+}
+
+// tick returns the shortest check interval of the records, the rate the
+// main loop looks for due records at.
+func (s *Setting) tick() time.Duration {
+	tick := checkInterval
+	for _, p := range s.Providers {
+		tick = min(tick, recordInterval(p.Options()))
+	}
+	return tick
+}
+
+// due returns the records whose interval has passed at now since they were
+// last checked, allowing for half a tick of jitter.
+func (s *Setting) due(now time.Time) *Setting {
+	tick := s.tick()
+	due := &Setting{items: s.items}
+	for _, p := range s.Providers {
+		if now.Sub(lastChecked[p.Name()]) >= recordInterval(p.Options())-tick/2 {
+			due.Providers = append(due.Providers, p)
+		}
+	}
+	return due
+}
+
+var (
+	insecureSkipVerify bool
+	ifconfigURL        string
+	networkStack       string
+	// currentIPs maps addressKey of the records to their current address
+	currentIPs = make(map[string]string)
+	// lastValues maps provider names to the values of their records as of
+	// the last time they were submitted
+	lastValues = make(map[string]map[string]string)
+	// lastChecked maps provider names to the time their records were last checked
+	lastChecked   = make(map[string]time.Time)
+	checkInterval = time.Minute
+)
+
+// detectIPs detects the addresses of the records, external ones by asking
+// the detector for their sources, so every address is detected once no
+// matter how many records share it. Addresses which can't be detected are
+// logged and left out, those of other records are kept. Cancelling ctx
+// aborts the detection.
+func detectIPs(ctx context.Context, setting *Setting, external *detectorSet) {
+	detected := make(map[string]bool)
+	for _, p := range setting.Providers {
+		o := p.Options()
+		if o.LAN != nil {
+			// the addresses of LAN hosts come from their leases
+			continue
+		}
+		for _, ipv4 := range []bool{true, false} {
+			key := addressKey(o, ipv4)
+			if detected[key] || !stackHas(recordStack(o), ipv4) {
+				continue
 			}
+			detected[key] = true
+			delete(currentIPs, key)
+			ip, err := detectIP(ctx, o, external, ipv4)
+			if err != nil {
+				log.Printf("detecting %s address failed: %v\n", key, err)
+				continue
+			}
+			currentIPs[key] = ip
 		}
 	}
+}
 
-	return ips, nil
+// detectIP returns the address of the family a record with the options o publishes.
+func detectIP(ctx context.Context, o *models.RecordOptions, external *detectorSet, ipv4 bool) (string, error) {
+	suffix, bits, hostRecord, err := hostSuffix(o)
+	if err != nil {
+		return "", err
+	}
+	hostRecord = hostRecord && !ipv4
+	if o.Internal || hostRecord {
+		selector, err := newInterfaceSelector(o.Interface)
+		if err != nil {
+			return "", err
+		}
+		addr, err := selector.selectAddr(ipv4)
+		if err != nil {
+			return "", err
+		}
+		if hostRecord {
+			// the prefix delegated to the LAN, with the LAN host's interface identifier
+			addr = withSuffix(addr, bits, suffix)
+		}
+		return addr.String(), nil
+	}
+	d, err := external.get(o)
+	if err != nil {
+		return "", err
+	}
+	ctx, cancel := context.WithTimeout(withBind(ctx, o.Bind), requestTimeout)
+	defer cancel()
+	return d.detect(ctx, ipv4)
 }
 
 func getCurrentExternalIP(ipv4 bool) (string, error) {
-	parse, err := url.Parse(ifconfigURL)
+	return fetchExternalIP(context.Background(), ifconfigURL, ipv4)
+}
+
+// fetchExternalIP asks the ifconfig service at ifconfig for the external
+// address, connecting over IPv4 or IPv6 to get the address of that family.
+func fetchExternalIP(ctx context.Context, ifconfig string, ipv4 bool) (string, error) {
+	parse, err := url.Parse(ifconfig)
 	if err != nil {
 		log.Println("can't parse ifconfig URL", err)
 		return "", err
 	}
-	ips, err := net.LookupIP(parse.Host)
+	extract, err := newResponseExtractor(parse.Fragment)
+	if err != nil {
+		return "", err
+	}
+	ips, err := net.DefaultResolver.LookupIP(ctx, "ip", parse.Hostname())
 	if err != nil {
 		log.Println("can't lookup IP", err)
 		return "", err
@@ -84,7 +243,7 @@ func getCurrentExternalIP(ipv4 bool) (string, error) {
 			}
 			break
 		}
-		if ipv4 == false && ip.To16() != nil {
+		if ipv4 == false && ip.To4() == nil {
 			if parse.Port() != "" {
 				targetURL = fmt.Sprintf("[%s]:%s", ip.To16().String(), parse.Port())
 			} else {
@@ -97,7 +256,10 @@ func getCurrentExternalIP(ipv4 bool) (string, error) {
 			break
 		}
 	}
-	req, err := http.NewRequest("GET", ifconfigURL, nil)
+	if len(targetURL) == 0 {
+		return "", fmt.Errorf("%s has no address to connect over %s", parse.Host, familyName(ipv4))
+	}
+	req, err := http.NewRequestWithContext(ctx, "GET", ifconfig, nil)
 	if err != nil {
 		fmt.Println("create request to ifconfig failed", err)
 		return "", err
@@ -105,6 +267,7 @@ func getCurrentExternalIP(ipv4 bool) (string, error) {
 	req.Header.Set("User-Agent", "curl/7.41.0")
 
 	client := &http.Client{
+		Timeout: requestTimeout,
 		Transport: &http.Transport{
 			TLSClientConfig: &tls.Config{
 				InsecureSkipVerify: insecureSkipVerify,
@@ -113,20 +276,25 @@ func getCurrentExternalIP(ipv4 bool) (string, error) {
 				if strings.HasPrefix(addr, parse.Host) {
 					addr = targetURL
 				}
-				dialer := &net.Dialer{
-					Timeout:   30 * time.Second,
-					KeepAlive: 30 * time.Second,
+				dialer, err := newDialer(ctx, network)
+				if err != nil {
+					return nil, err
 				}
+				dialer.Timeout = 30 * time.Second
+				dialer.KeepAlive = 30 * time.Second
 				return dialer.DialContext(ctx, network, addr)
 			},
 		},
 	}
 	resp, err := client.Do(req)
 	if err != nil {
-		fmt.Printf("request %s failed", ifconfigURL)
+		fmt.Printf("request %s failed\n", ifconfig)
 		return "", err
 	}
 	defer resp.Body.Close()
+	if err = checkResponse(resp); err != nil {
+		return "", err
+	}
 
 	body, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
@@ -134,173 +302,262 @@ func getCurrentExternalIP(ipv4 bool) (string, error) {
 		return "", err
 	}
 
-	for i := len(body); i > 0 && (body[i-1] < '0' || body[i-1] > '9'); i = len(body) {
-		body = body[:i-1]
-	}
-
-	if matched, err := regexp.Match(`^((25[0-5]|(2[0-4]|1{0,1}[0-9]){0,1}[0-9])\.){3,3}(25[0-5]|(2[0-4]|1{0,1}[0-9]){0,1}[0-9])$`, body); err == nil && matched == true {
-		return string(body), nil
+	value, err := extract(resp, body)
+	if err != nil {
+		return "", err
 	}
-
-	if matched, err := regexp.Match(`^(([0-9a-fA-F]{1,4}:){7,7}[0-9a-fA-F]{1,4}|([0-9a-fA-F]{1,4}:){1,7}:|([0-9a-fA-F]{1,4}:){1,6}:[0-9a-fA-F]{1,4}|([0-9a-fA-F]{1,4}:){1,5}(:[0-9a-fA-F]{1,4}){1,2}|([0-9a-fA-F]{1,4}:){1,4}(:[0-9a-fA-F]{1,4}){1,3}|([0-9a-fA-F]{1,4}:){1,3}(:[0-9a-fA-F]{1,4}){1,4}|([0-9a-fA-F]{1,4}:){1,2}(:[0-9a-fA-F]{1,4}){1,5}|[0-9a-fA-F]{1,4}:((:[0-9a-fA-F]{1,4}){1,6})|:((:[0-9a-fA-F]{1,4}){1,7}|:)|fe80:(:[0-9a-fA-F]{0,4}){0,4}%[0-9a-zA-Z]{1,}|::(ffff(:0{1,4}){0,1}:){0,1}((25[0-5]|(2[0-4]|1{0,1}[0-9]){0,1}[0-9])\.){3,3}(25[0-5]|(2[0-4]|1{0,1}[0-9]){0,1}[0-9])|([0-9a-fA-F]{1,4}:){1,4}:((25[0-5]|(2[0-4]|1{0,1}[0-9]){0,1}[0-9])\.){3,3}(25[0-5]|(2[0-4]|1{0,1}[0-9]){0,1}[0-9]))$`, body); err == nil && matched == true {
-		return string(body), nil
+	addr, err := parseAddr(value)
+	if err != nil {
+		return "", err
 	}
+	return addr.String(), nil
+}
 
-	return "", errors.New("invalid IP address: " + string(body))
+// forgetLastValues makes the next updateDDNS call submit all records.
+func forgetLastValues() {
+	clear(lastValues)
 }
 
-func updateDDNS(setting *Setting) {
-	var err error
-	if networkStack == "ipv4" || networkStack == "dual" {
-		currentExternalIPv4, err = getCurrentExternalIP(true)
-		if err != nil {
-			fmt.Println(err)
-			return
+// failedRecord reports whether a record of p failed to publish with an
+// error retrying may fix, after the retries of the scheduler gave up. Such
+// records are submitted again on every check until they succeed.
+func failedRecord(p Provider, state *stateStore) bool {
+	for recordType := range recordValues(p.Options()) {
+		if st, ok := state.get(recordKey(p, recordType)); ok && st.failed() {
+			log.Printf("%s record of %s failed: %s, retrying\n", recordType, p.Name(), st.Result)
+			return true
 		}
-		currentInternalIPsV4, e := getCurrentInternalIPs(true)
-		if e != nil {
-			fmt.Println(err)
-			return
-		}
-		currentInternalIPv4 = currentInternalIPsV4[0]
 	}
+	return false
+}
 
-	if networkStack == "ipv6" || networkStack == "dual" {
-		currentExternalIPv6, err = getCurrentExternalIP(false)
-		if err != nil {
-			fmt.Println(err)
-			return
-		}
-		currentInternalIPsV6, e := getCurrentInternalIPs(false)
-		if e != nil {
-			fmt.Println(err)
-			return
-		}
-		currentInternalIPv6 = currentInternalIPsV6[0]
-	}
-	log.Println("current external ip:", currentExternalIPv4, currentExternalIPv6)
-	log.Println("current internal ip:", currentInternalIPv4, currentInternalIPv6)
-	basicAuth := func(v models.BasicAuthConfigurationItem) {
-		for {
-			if err := basicAuthorizeHttpRequest(v.UserName, v.Password, v.Url); err == nil {
-				break
-			}
-			time.Sleep(1 * time.Minute)
-		}
+// updateDDNS detects the current addresses of the records in setting with
+// external and submits the records to publish to sched, it must not run
+// concurrently with itself. Nothing is submitted once ctx is done.
+// Unless force is set, a record is only submitted if its addresses changed
+// since it was last submitted, a change of it is held back or it failed.
+func updateDDNS(ctx context.Context, setting *Setting, external *detectorSet, sched *scheduler, force bool) {
+	now := time.Now()
+	for _, p := range setting.Providers {
+		lastChecked[p.Name()] = now
 	}
-
-	dnspod := func(v models.DnspodConfigurationItem) {
-		for {
-			if len(v.Token) != 0 && len(v.TokenId) != 0 {
-				if err := dnspodRequestByToken(v.TokenId, v.Token, v.Domain, v.SubDomain, v.Internal); err == nil {
-					break
-				}
-			} else if len(v.UserName) != 0 && len(v.Password) != 0 {
-				if err := dnspodRequest(v.UserName, v.Password, v.Domain, v.SubDomain, v.Internal); err == nil {
-					break
-				}
-			}
-			time.Sleep(1 * time.Minute)
-		}
+	detectIPs(ctx, setting, external)
+	if ctx.Err() != nil {
+		// shutting down, the addresses are incomplete
+		return
 	}
-
-	cloudflare := func(v models.CloudflareConfigurationItem) {
-		for {
-			if err := cloudflareRequest(v.UserName, v.Token, v.Domain, v.SubDomain, v.Internal); err == nil {
-				break
-			}
-			time.Sleep(1 * time.Minute)
-		}
+	for _, key := range slices.Sorted(maps.Keys(currentIPs)) {
+		log.Printf("current ip (%s): %s\n", key, currentIPs[key])
 	}
-
-	cloudxns := func(v models.CloudXNSConfigurationItem) {
-		for {
-			if err := cloudxnsRequest(v.APIKey, v.SecretKey, v.Domain, v.SubDomain, v.Internal); err == nil {
-				break
-			}
-			time.Sleep(1 * time.Minute)
-		}
+	holding := flaps.holding()
+	if force {
+		log.Println("forced update, records are checked against the DNS services")
 	}
-	if ((networkStack == "ipv4" || networkStack == "dual") && (len(currentExternalIPv4) != 0 && lastExternalIPv4 != currentExternalIPv4) || (len(currentInternalIPv4) != 0 && lastInternalIPv4 != currentInternalIPv4)) ||
-		((networkStack == "ipv6" || networkStack == "dual") && (len(currentExternalIPv6) != 0 && lastExternalIPv6 != currentExternalIPv6) || (len(currentInternalIPv6) != 0 && lastInternalIPv6 != currentInternalIPv6)) {
-		for _, v := range setting.BasicAuthItems {
-			go basicAuth(v)
-		}
-
-		for _, v := range setting.DnspodItems {
-			go dnspod(v)
-		}
-
-		for _, v := range setting.CloudflareItems {
-			go cloudflare(v)
-		}
-
-		for _, v := range setting.CloudXNSItems {
-			go cloudxns(v)
+	for _, p := range setting.Providers {
+		if p.Options().LAN != nil {
+			continue
 		}
-		if (networkStack == "ipv4" || networkStack == "dual") && len(currentExternalIPv4) != 0 {
-			lastExternalIPv4 = currentExternalIPv4
+		values := recordValues(p.Options())
+		// records sharing an address but checked at other intervals compare
+		// with what they submitted themselves
+		last, ok := lastValues[p.Name()]
+		if !force && !holding && ok && maps.Equal(values, last) && !failedRecord(p, sched.state) {
+			continue
 		}
-		if (networkStack == "ipv4" || networkStack == "dual") && len(currentInternalIPv4) != 0 {
-			lastInternalIPv4 = currentInternalIPv4
+		lastValues[p.Name()] = maps.Clone(values)
+		values = guardValues(p, values)
+		if !force {
+			values = flaps.filter(p, values, sched.state, time.Now())
 		}
-		if (networkStack == "ipv6" || networkStack == "dual") && len(currentExternalIPv6) != 0 {
-			lastExternalIPv6 = currentExternalIPv6
+		if len(values) != 0 {
+			sched.submit(p, values, force)
 		}
-		if (networkStack == "ipv6" || networkStack == "dual") && len(currentInternalIPv6) != 0 {
-			lastInternalIPv6 = currentInternalIPv6
+	}
+	for _, p := range setting.Providers {
+		if p.Options().LAN != nil {
+			lanHosts.update(setting, p, sched)
 		}
 	}
 }
 
-var conf string
+var (
+	conf            string
+	shutdownTimeout = 10 * time.Second
+	watchInterval   = 5 * time.Second
+	netDebounce     = 2 * time.Second
+)
 
 func main() {
 	flag.BoolVar(&insecureSkipVerify, "insecureSkipVerify", false, "if true, TLS accepts any certificate")
 	flag.StringVar(&ifconfigURL, "ifconfig", "https://ifconfig.minidump.info", "set ifconfig URL")
+	var sources string
+	flag.StringVar(&sources, "sources", "", "set comma separated IP sources to ask instead of the ifconfig URL, e.g. https://if.yii.li,https://ifconfig.minidump.info")
+	var quorum int
+	flag.IntVar(&quorum, "quorum", 1, "set how many IP sources have to agree on the address")
 	flag.StringVar(&conf, "config", "app.conf", "set application config")
+	flag.DurationVar(&requestTimeout, "timeout", requestTimeout, "set timeout of each request to ifconfig and DNS service APIs")
+	flag.IntVar(&retry.maxAttempts, "retries", retry.maxAttempts, "set max attempts to update a record before giving up")
+	flag.DurationVar(&retry.baseDelay, "retryDelay", retry.baseDelay, "set delay before the first retry, doubled for every further retry")
+	flag.DurationVar(&retry.maxDelay, "maxRetryDelay", retry.maxDelay, "set max delay between retries")
+	var statePath string
+	flag.StringVar(&statePath, "state", "state.json", "set state file path to remember published records, empty to disable")
 	flag.StringVar(&networkStack, "stack", "ipv4", "set network stack, available values: ipv4, ipv6, dual")
+	flag.DurationVar(&shutdownTimeout, "shutdownTimeout", shutdownTimeout, "set how long to wait for in-flight updates on SIGTERM")
+	flag.DurationVar(&watchInterval, "watch", watchInterval, "set how often to check the config file for changes, 0 to disable")
+	flag.BoolVar(&allowBogons, "allowBogons", false, "if true, external addresses in private, carrier-grade NAT and other reserved ranges are published too")
+	var netWatch bool
+	flag.BoolVar(&netWatch, "netwatch", true, "if true, update as soon as the network changes, Linux only")
+	flag.DurationVar(&netDebounce, "debounce", netDebounce, "set how long the network has to stay quiet before a network change triggers an update")
 	var interval string
-	flag.StringVar(&interval, "interval", "1m", "set update interval, available values: 1m, 5m, 10m, 30m, 1h, 2h, 6h, 12h, 1d")
+	flag.StringVar(&interval, "interval", "1m", "set update interval, e.g. 1m, 5m, 1h, each item in app.conf can override it with an \"interval\" field")
 	var singleShot bool
 	flag.BoolVar(&singleShot, "singleShot", false, "if true, update once and exit")
 	flag.Parse()
 
 	fmt.Println("Dynamic DNS client")
-	appConf, err := os.Open(conf)
+	setting, err := readSetting(conf)
 	if err != nil {
-		fmt.Println("opening app.conf failed:", err)
-		return
+		fmt.Println(err)
+		os.Exit(1)
 	}
 
-	defer func() {
-		appConf.Close()
-	}()
-
-	b, err := ioutil.ReadAll(appConf)
+	state, err := loadState(statePath)
 	if err != nil {
-		fmt.Println("reading app.conf failed:", err)
-		return
+		// the state only saves writes, the records are checked against the services instead
+		log.Println("loading state failed, starting without it:", err)
+	}
+	sched := newScheduler(state, retry)
+
+	if len(sources) == 0 {
+		sources = ifconfigURL
 	}
-	setting := new(Setting)
-	err = json.Unmarshal(b, &setting)
+	fallback, err := newDetectorFromSpecs(sources, quorum)
 	if err != nil {
-		fmt.Println("unmarshalling app.conf failed:", err)
+		fmt.Println("invalid IP sources:", err)
+		os.Exit(1)
+	}
+	external := newDetectorSet(fallback, quorum)
+	if checkInterval, err = time.ParseDuration(interval); err != nil || checkInterval <= 0 {
+		log.Fatalf("invalid interval %q\n", interval)
+	}
+
+	// a stop signal cancels ctx, aborting a running detection, and shuts down
+	ctx, cancel := signal.NotifyContext(context.Background(), stopSignals...)
+	defer cancel()
+	updateDDNS(ctx, setting, external, sched, false)
+	if singleShot {
+		done := make(chan struct{})
+		go func() {
+			sched.wait()
+			close(done)
+		}()
+		select {
+		case <-done:
+		case <-ctx.Done():
+			log.Println("received stop signal, shutting down")
+			os.Exit(shutdown(sched, state, shutdownTimeout))
+		}
 		return
 	}
 
-	updateDDNS(setting)
-	if !singleShot {
-		duration, err := time.ParseDuration(interval)
+	// every record is checked at its own interval, the ticker runs at the shortest one
+	timer := time.NewTicker(setting.tick())
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, append(reloadSignals, updateSignals...)...)
+	reload := func() {
+		next, err := readSetting(conf)
 		if err != nil {
-			log.Fatal(err)
+			log.Println("reloading config failed, keep running with the old one:", err)
+			return
+		}
+		setting = applySetting(setting, next, sched, state)
+		timer.Reset(setting.tick())
+		// publish added and changed records, unchanged ones are skipped by the state store
+		forgetLastValues()
+		updateDDNS(ctx, setting, external, sched, false)
+	}
+	var watch <-chan time.Time
+	watcher := newConfigWatcher(conf)
+	if watchInterval > 0 {
+		watchTimer := time.NewTicker(watchInterval)
+		watch = watchTimer.C
+	}
+	var netEvents <-chan struct{}
+	if netWatch {
+		if netEvents, err = watchNetwork(); err != nil {
+			log.Println("can't watch network changes, polling only:", err)
 		}
-		timer := time.NewTicker(duration) // every 1 minute
-		for {
-			select {
-			case <-timer.C:
-				go updateDDNS(setting)
+	}
+	// network changes come in bursts, update once they have settled
+	debounce := time.NewTimer(netDebounce)
+	debounce.Stop()
+	for {
+		select {
+		case now := <-timer.C:
+			// a slow cycle makes the ticker drop ticks instead of overlapping,
+			// with network changes watched it's a safety net
+			if due := setting.due(now); len(due.Providers) != 0 {
+				updateDDNS(ctx, due, external, sched, false)
+			}
+		case <-netEvents:
+			debounce.Reset(netDebounce)
+		case <-debounce.C:
+			log.Println("network changed, updating now")
+			updateDDNS(ctx, setting, external, sched, false)
+		case <-watch:
+			if watcher.changed() {
+				log.Printf("%s changed, reloading\n", conf)
+				reload()
+			}
+		case <-ctx.Done():
+			log.Println("received stop signal, shutting down")
+			timer.Stop()
+			signal.Stop(signals)
+			os.Exit(shutdown(sched, state, shutdownTimeout))
+		case sig := <-signals:
+			switch {
+			case slices.Contains(reloadSignals, sig):
+				log.Printf("received %v, reloading %s\n", sig, conf)
+				watcher.changed()
+				reload()
+			case slices.Contains(updateSignals, sig):
+				log.Printf("received %v, updating now\n", sig)
+				updateDDNS(ctx, setting, external, sched, true)
 			}
 		}
 	}
 }
+
+// shutdown waits for in-flight updates and saves the state, the returned exit
+// code is 0 if everything finished in time.
+func shutdown(sched *scheduler, state *stateStore, timeout time.Duration) int {
+	code := 0
+	if !sched.shutdown(timeout) {
+		log.Printf("updates didn't finish in %v and were aborted\n", timeout)
+		code = 1
+	}
+	if err := state.flush(); err != nil {
+		log.Println("saving state failed:", err)
+		code = 1
+	}
+	return code
+}
+
+// readSetting reads and validates the config file at path.
+func readSetting(path string) (*Setting, error) {
+	appConf, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("opening app.conf failed: %w", err)
+	}
+	defer appConf.Close()
+
+	b, err := ioutil.ReadAll(appConf)
+	if err != nil {
+		return nil, fmt.Errorf("reading app.conf failed: %w", err)
+	}
+	setting, err := loadSetting(b)
+	if err != nil {
+		return nil, fmt.Errorf("unmarshalling app.conf failed: %w", err)
+	}
+	return setting, nil
+}
@@ -19,3 +19,13 @@ func TestGetCurrentExternalIP(t *testing.T) {
 	}
 	log.Println(ip)
 }
+
+func TestReadSampleSetting(t *testing.T) {
+	setting, err := readSetting("app.conf.sample")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(setting.Providers) != 6 {
+		t.Errorf("loaded %d items, want 6", len(setting.Providers))
+	}
+}
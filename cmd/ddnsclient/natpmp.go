@@ -0,0 +1,212 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/netip"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// NAT-PMP (RFC 6886) and PCP (RFC 6887) constants
+const (
+	natpmpPort           = "5351"
+	natpmpVersion        = 0
+	natpmpOpExternalAddr = 0
+	pcpVersion           = 2
+	pcpOpMap             = 1
+	pcpHeaderSize        = 24
+	pcpMapSize           = 36
+	gatewayInitialRTO    = 250 * time.Millisecond
+	gatewayMaxRequests   = 6
+	pcpProbeLifetime     = 30 * time.Second
+)
+
+func init() {
+	registerIPSource("natpmp", newGatewaySource)
+	registerIPSource("pcp", newGatewaySource)
+}
+
+// gatewaySource asks the gateway for its public IPv4 address by NAT-PMP or
+// PCP, e.g. "natpmp:" for the default gateway or "pcp://192.168.1.1".
+type gatewaySource struct {
+	pcp     bool
+	gateway string
+	nonce   [12]byte
+}
+
+func newGatewaySource(spec string) (ipSource, error) {
+	u, err := url.Parse(spec)
+	if err != nil {
+		return nil, err
+	}
+	if len(u.RawQuery) != 0 {
+		return nil, fmt.Errorf("%s: NAT-PMP and PCP sources take no options", spec)
+	}
+	s := &gatewaySource{pcp: strings.EqualFold(u.Scheme, "pcp")}
+	if len(u.Host) != 0 {
+		s.gateway = u.Host
+		if len(u.Port()) == 0 {
+			s.gateway = net.JoinHostPort(u.Hostname(), natpmpPort)
+		}
+	}
+	if _, err = rand.Read(s.nonce[:]); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *gatewaySource) Name() string {
+	scheme := "natpmp"
+	if s.pcp {
+		scheme = "pcp"
+	}
+	if len(s.gateway) == 0 {
+		return scheme + ":"
+	}
+	return scheme + "://" + s.gateway
+}
+
+func (s *gatewaySource) Detect(ctx context.Context, ipv4 bool) (netip.Addr, error) {
+	if !ipv4 {
+		return netip.Addr{}, errors.New("NAT-PMP and PCP only report an IPv4 address")
+	}
+	if s.pcp {
+		// PCP has no request for the address alone, map a short lived port and drop it again
+		mapped, err := s.pcpMap(ctx, "udp", 9, pcpProbeLifetime)
+		if err != nil {
+			return netip.Addr{}, err
+		}
+		if _, err = s.pcpMap(ctx, "udp", 9, 0); err != nil {
+			log.Printf("removing probe mapping from %s failed: %v\n", s.Name(), err)
+		}
+		return mapped.Addr(), nil
+	}
+
+	resp, err := s.exchange(ctx, []byte{natpmpVersion, natpmpOpExternalAddr}, func(resp []byte) bool {
+		return len(resp) >= 12 && resp[1] == 128+natpmpOpExternalAddr
+	})
+	if err != nil {
+		return netip.Addr{}, err
+	}
+	if err = natpmpResult(binary.BigEndian.Uint16(resp[2:])); err != nil {
+		return netip.Addr{}, err
+	}
+	return netip.AddrFrom4([4]byte(resp[8:12])), nil
+}
+
+// pcpMap maps internalPort of the given protocol, "tcp" or "udp", for
+// lifetime and returns the external address and port, a zero lifetime
+// deletes the mapping.
+func (s *gatewaySource) pcpMap(ctx context.Context, protocol string, internalPort uint16, lifetime time.Duration) (netip.AddrPort, error) {
+	proto := byte(6)
+	if protocol == "udp" {
+		proto = 17
+	}
+	req := make([]byte, pcpHeaderSize+pcpMapSize)
+	req[0], req[1] = pcpVersion, pcpOpMap
+	binary.BigEndian.PutUint32(req[4:], uint32(lifetime/time.Second))
+	// req[8:24] is the client address, filled in by exchange
+	copy(req[24:36], s.nonce[:])
+	req[36] = proto
+	binary.BigEndian.PutUint16(req[40:], internalPort)
+	binary.BigEndian.PutUint16(req[42:], internalPort)
+	// suggest any external IPv4 address
+	ipv4Any := netip.AddrFrom4([4]byte{}).As16()
+	ipv4Any[10], ipv4Any[11] = 0xff, 0xff
+	copy(req[44:60], ipv4Any[:])
+
+	resp, err := s.exchange(ctx, req, func(resp []byte) bool {
+		return len(resp) >= pcpHeaderSize+pcpMapSize && resp[0] == pcpVersion && resp[1] == 0x80|pcpOpMap &&
+			string(resp[24:36]) == string(s.nonce[:]) && resp[36] == proto && binary.BigEndian.Uint16(resp[40:]) == internalPort
+	})
+	if err != nil {
+		return netip.AddrPort{}, err
+	}
+	if code := resp[3]; code != 0 {
+		return netip.AddrPort{}, fmt.Errorf("PCP MAP failed with result code %d", code)
+	}
+	addr := netip.AddrFrom16([16]byte(resp[44:60])).Unmap()
+	return netip.AddrPortFrom(addr, binary.BigEndian.Uint16(resp[42:])), nil
+}
+
+// exchange sends req to the gateway until a response passing valid arrives,
+// doubling the wait after each retransmission. PCP requests get the local
+// address of the socket as client address.
+func (s *gatewaySource) exchange(ctx context.Context, req []byte, valid func([]byte) bool) ([]byte, error) {
+	gateway := s.gateway
+	if len(gateway) == 0 {
+		gw, err := defaultGateway()
+		if err != nil {
+			return nil, err
+		}
+		gateway = net.JoinHostPort(gw.String(), natpmpPort)
+	}
+	conn, err := dialContext(ctx, "udp4", gateway)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	stop := context.AfterFunc(ctx, func() {
+		conn.SetReadDeadline(time.Now())
+	})
+	defer stop()
+
+	if req[0] == pcpVersion {
+		local := conn.LocalAddr().(*net.UDPAddr).AddrPort().Addr()
+		client := netip.AddrFrom4(local.Unmap().As4()).As16()
+		client[10], client[11] = 0xff, 0xff
+		copy(req[8:24], client[:])
+	}
+
+	buf := make([]byte, 1100)
+	rto := gatewayInitialRTO
+	for i := 0; i < gatewayMaxRequests; i++ {
+		if _, err = conn.Write(req); err != nil {
+			return nil, err
+		}
+		conn.SetReadDeadline(time.Now().Add(rto))
+		rto *= 2
+		for {
+			var n int
+			n, err = conn.Read(buf)
+			if err != nil {
+				break
+			}
+			if valid(buf[:n]) {
+				return buf[:n], nil
+			}
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		var netErr net.Error
+		if !errors.As(err, &netErr) || !netErr.Timeout() {
+			return nil, err
+		}
+	}
+	return nil, fmt.Errorf("no response from gateway %s", gateway)
+}
+
+func natpmpResult(code uint16) error {
+	switch code {
+	case 0:
+		return nil
+	case 1:
+		return errors.New("NAT-PMP version not supported by gateway")
+	case 2:
+		return errors.New("NAT-PMP refused by gateway")
+	case 3:
+		return errors.New("NAT-PMP gateway has no external address")
+	case 4:
+		return errors.New("NAT-PMP gateway is out of resources")
+	default:
+		return fmt.Errorf("NAT-PMP failed with result code %d", code)
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+)
+
+// serveGateway answers NAT-PMP and PCP requests on conn with the external
+// address ext, PCP mappings map every internal port p to p+1000.
+func serveGateway(conn net.PacketConn, ext netip.Addr) {
+	buf := make([]byte, 1100)
+	for {
+		n, from, err := conn.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		req := buf[:n]
+		switch {
+		case n == 2 && req[0] == natpmpVersion && req[1] == natpmpOpExternalAddr:
+			resp := make([]byte, 12)
+			resp[1] = 128 + natpmpOpExternalAddr
+			copy(resp[8:], ext.AsSlice())
+			conn.WriteTo(resp, from)
+		case n == pcpHeaderSize+pcpMapSize && req[0] == pcpVersion && req[1] == pcpOpMap:
+			resp := make([]byte, n)
+			copy(resp, req)
+			resp[1] = 0x80 | pcpOpMap
+			clear(resp[8:24])
+			binary.BigEndian.PutUint16(resp[42:], binary.BigEndian.Uint16(req[40:])+1000)
+			mapped := netip.AddrFrom4(ext.As4()).As16()
+			mapped[10], mapped[11] = 0xff, 0xff
+			copy(resp[44:60], mapped[:])
+			conn.WriteTo(resp, from)
+		}
+	}
+}
+
+func TestGatewaySource(t *testing.T) {
+	conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	ext := netip.MustParseAddr("203.0.113.9")
+	go serveGateway(conn, ext)
+
+	for _, scheme := range []string{"natpmp", "pcp"} {
+		src, err := newIPSource(scheme + "://" + conn.LocalAddr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		addr, err := src.Detect(ctx, true)
+		cancel()
+		if err != nil {
+			t.Fatalf("%s: %v", scheme, err)
+		}
+		if addr != ext {
+			t.Fatalf("%s: got %s, want %s", scheme, addr, ext)
+		}
+	}
+
+	src, _ := newIPSource("natpmp://" + conn.LocalAddr().String())
+	if addr, err := src.Detect(context.Background(), false); err == nil {
+		t.Fatalf("got IPv6 address %s from NAT-PMP", addr)
+	}
+}
+
+func TestGatewaySourceSpec(t *testing.T) {
+	for _, spec := range []string{"natpmp:?map=tcp:443", "pcp://192.168.1.1?lifetime=1h"} {
+		if _, err := newIPSource(spec); err == nil {
+			t.Errorf("%s accepted", spec)
+		}
+	}
+	src, err := newIPSource("natpmp://192.168.1.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name := src.Name(); name != "natpmp://192.168.1.1:5351" {
+		t.Errorf("got name %s", name)
+	}
+}
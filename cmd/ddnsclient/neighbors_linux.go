@@ -0,0 +1,67 @@
+package main
+
+import (
+	"encoding/binary"
+	"net"
+	"net/netip"
+	"syscall"
+)
+
+// struct ndmsg and the NDA_* attributes and NUD_* states of neighbor
+// messages, syscall doesn't parse their attributes.
+const (
+	sizeofNdmsg   = 12
+	ndaDst        = 1
+	ndaLLAddr     = 2
+	nudIncomplete = 0x01
+	nudFailed     = 0x20
+	nudNoARP      = 0x40
+)
+
+// neighborTable reads the ARP and NDP neighbor table from the kernel by
+// netlink, the addresses keyed by MAC address.
+func neighborTable() (map[string][]netip.Addr, error) {
+	rib, err := syscall.NetlinkRIB(syscall.RTM_GETNEIGH, syscall.AF_UNSPEC)
+	if err != nil {
+		return nil, err
+	}
+	msgs, err := syscall.ParseNetlinkMessage(rib)
+	if err != nil {
+		return nil, err
+	}
+	neighbors := make(map[string][]netip.Addr)
+	for i := range msgs {
+		m := &msgs[i]
+		if m.Header.Type != syscall.RTM_NEWNEIGH || len(m.Data) < sizeofNdmsg {
+			continue
+		}
+		// struct ndmsg: family, pad, pad, ifindex, state, flags, type
+		if binary.NativeEndian.Uint16(m.Data[8:10])&(nudIncomplete|nudFailed|nudNoARP) != 0 {
+			continue
+		}
+		var addr netip.Addr
+		var mac net.HardwareAddr
+		for b := m.Data[sizeofNdmsg:]; len(b) >= syscall.SizeofRtAttr; {
+			l := int(binary.NativeEndian.Uint16(b[0:2]))
+			if l < syscall.SizeofRtAttr || l > len(b) {
+				break
+			}
+			switch binary.NativeEndian.Uint16(b[2:4]) {
+			case ndaDst:
+				addr, _ = netip.AddrFromSlice(b[syscall.SizeofRtAttr:l])
+			case ndaLLAddr:
+				mac = net.HardwareAddr(b[syscall.SizeofRtAttr:l])
+			}
+			l = (l + syscall.RTA_ALIGNTO - 1) &^ (syscall.RTA_ALIGNTO - 1)
+			if l > len(b) {
+				break
+			}
+			b = b[l:]
+		}
+		if !addr.IsValid() || len(mac) != 6 {
+			continue
+		}
+		neighbors[mac.String()] = append(neighbors[mac.String()], addr.Unmap())
+	}
+	return neighbors, nil
+}
@@ -0,0 +1,14 @@
+//go:build !linux
+
+package main
+
+import (
+	"errors"
+	"net/netip"
+)
+
+// neighborTable isn't implemented on this platform, hosts only get the
+// addresses of their leases.
+func neighborTable() (map[string][]netip.Addr, error) {
+	return nil, errors.New("reading the neighbor table is only supported on Linux")
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"log"
+	"os"
+	"syscall"
+)
+
+// netlinkGroups subscribes to address changes and route changes of both families.
+const netlinkGroups = 1<<(syscall.RTNLGRP_IPV4_IFADDR-1) | 1<<(syscall.RTNLGRP_IPV6_IFADDR-1) |
+	1<<(syscall.RTNLGRP_IPV4_ROUTE-1) | 1<<(syscall.RTNLGRP_IPV6_ROUTE-1)
+
+// watchNetwork subscribes to the kernel's address and route change events,
+// the returned channel receives a value whenever an address is added or
+// removed or a default route changes, e.g. when PPPoE reconnects. Events
+// which arrive while the previous one hasn't been received are merged.
+func watchNetwork() (<-chan struct{}, error) {
+	fd, err := syscall.Socket(syscall.AF_NETLINK, syscall.SOCK_RAW|syscall.SOCK_CLOEXEC|syscall.SOCK_NONBLOCK, syscall.NETLINK_ROUTE)
+	if err != nil {
+		return nil, err
+	}
+	if err = syscall.Bind(fd, &syscall.SockaddrNetlink{Family: syscall.AF_NETLINK, Groups: netlinkGroups}); err != nil {
+		syscall.Close(fd)
+		return nil, err
+	}
+	// a non-blocking file goes through the runtime poller instead of blocking a thread
+	f := os.NewFile(uintptr(fd), "netlink")
+	events := make(chan struct{}, 1)
+	go func() {
+		defer f.Close()
+		readNetworkEvents(f, events)
+	}()
+	return events, nil
+}
+
+// readNetworkEvents reads netlink messages from r and sends a value to
+// events for those which change the network, until reading fails.
+func readNetworkEvents(r io.Reader, events chan<- struct{}) {
+	notify := func() {
+		select {
+		case events <- struct{}{}:
+		default:
+		}
+	}
+	buf := make([]byte, os.Getpagesize()*4)
+	for {
+		n, err := r.Read(buf)
+		if errors.Is(err, syscall.ENOBUFS) {
+			// the socket buffer overran in a burst of events, some are lost
+			// but the network has changed anyway
+			notify()
+			continue
+		}
+		if err != nil {
+			log.Println("watching network changes failed, falling back to polling:", err)
+			return
+		}
+		msgs, err := syscall.ParseNetlinkMessage(buf[:n])
+		if err != nil {
+			continue
+		}
+		for _, m := range msgs {
+			if networkChanged(m) {
+				notify()
+				break
+			}
+		}
+	}
+}
+
+// networkChanged reports whether m may change the external or internal addresses.
+func networkChanged(m syscall.NetlinkMessage) bool {
+	switch m.Header.Type {
+	case syscall.RTM_NEWADDR, syscall.RTM_DELADDR:
+		return true
+	case syscall.RTM_NEWROUTE, syscall.RTM_DELROUTE:
+		// only default routes, other routes come and go with containers and VPNs
+		return len(m.Data) >= syscall.SizeofRtMsg && m.Data[1] == 0
+	}
+	return false
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"io"
+	"os"
+	"syscall"
+	"testing"
+)
+
+func TestNetworkChanged(t *testing.T) {
+	route := func(typ uint16, dstLen byte) syscall.NetlinkMessage {
+		data := make([]byte, syscall.SizeofRtMsg)
+		data[1] = dstLen
+		return syscall.NetlinkMessage{Header: syscall.NlMsghdr{Type: typ}, Data: data}
+	}
+	for _, tc := range []struct {
+		m    syscall.NetlinkMessage
+		want bool
+	}{
+		{syscall.NetlinkMessage{Header: syscall.NlMsghdr{Type: syscall.RTM_NEWADDR}}, true},
+		{syscall.NetlinkMessage{Header: syscall.NlMsghdr{Type: syscall.RTM_DELADDR}}, true},
+		{route(syscall.RTM_NEWROUTE, 0), true},
+		{route(syscall.RTM_DELROUTE, 0), true},
+		{route(syscall.RTM_NEWROUTE, 24), false},
+		{syscall.NetlinkMessage{Header: syscall.NlMsghdr{Type: syscall.RTM_NEWLINK}}, false},
+	} {
+		if got := networkChanged(tc.m); got != tc.want {
+			t.Errorf("type %d: got %v, want %v", tc.m.Header.Type, got, tc.want)
+		}
+	}
+}
+
+func TestWatchNetwork(t *testing.T) {
+	if _, err := watchNetwork(); err != nil {
+		t.Skip("netlink isn't available:", err)
+	}
+}
+
+// errorReader returns its errors one by one, then io.EOF.
+type errorReader []error
+
+func (r *errorReader) Read(p []byte) (int, error) {
+	if len(*r) == 0 {
+		return 0, io.EOF
+	}
+	err := (*r)[0]
+	*r = (*r)[1:]
+	return 0, err
+}
+
+func TestReadNetworkEventsOverrun(t *testing.T) {
+	events := make(chan struct{}, 1)
+	r := &errorReader{&os.PathError{Op: "read", Path: "netlink", Err: syscall.ENOBUFS}, syscall.ENOBUFS}
+	readNetworkEvents(r, events)
+	if len(*r) != 0 {
+		t.Fatal("stopped reading after a buffer overrun")
+	}
+	select {
+	case <-events:
+	default:
+		t.Error("a buffer overrun isn't reported as a network change")
+	}
+}
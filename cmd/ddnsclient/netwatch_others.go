@@ -0,0 +1,10 @@
+//go:build !linux
+
+package main
+
+import "errors"
+
+// watchNetwork isn't implemented on this platform, changes are only noticed by polling.
+func watchNetwork() (<-chan struct{}, error) {
+	return nil, errors.New("watching network changes is only supported on Linux")
+}
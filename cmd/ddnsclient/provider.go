@@ -0,0 +1,192 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/netip"
+	"sort"
+	"time"
+
+	"github.com/missdeer/ddnsclient/models"
+)
+
+// Record is a DNS record as seen by a provider.
+type Record struct {
+	ID    string
+	Type  string
+	Value string
+}
+
+// Provider manages a single configured DNS record at a DNS service.
+type Provider interface {
+	// Name identifies the record, e.g. "dnspod:www.example.com".
+	Name() string
+	// Options returns the record settings shared by all providers.
+	Options() *models.RecordOptions
+	// Validate checks the configuration item without touching the network.
+	Validate() error
+	// CurrentRecord returns the record of the given type, or nil if it doesn't exist.
+	CurrentRecord(ctx context.Context, recordType string) (*Record, error)
+	// Upsert points the current record returned by CurrentRecord to value,
+	// or creates the record if current is nil.
+	Upsert(ctx context.Context, current *Record, recordType string, value string) error
+	// Delete removes the record of the given type.
+	Delete(ctx context.Context, recordType string) error
+}
+
+// providerFactory builds a provider from an item of its config section.
+type providerFactory func(raw json.RawMessage) (Provider, error)
+
+var (
+	requestTimeout = 30 * time.Second
+
+	providerFactories = make(map[string]providerFactory)
+
+	errNotSupported = errors.New("operation not supported by provider")
+)
+
+// registerProvider makes a provider available under the given config section name.
+func registerProvider(section string, factory providerFactory) {
+	if _, ok := providerFactories[section]; ok {
+		panic("provider registered twice: " + section)
+	}
+	providerFactories[section] = factory
+}
+
+// newProviders builds the providers for every item of every section of the
+// config, in a stable order. It also returns the compacted JSON of each item
+// keyed by provider name, so a reload can tell which items changed.
+func newProviders(sections map[string]json.RawMessage) ([]Provider, map[string]string, error) {
+	names := make([]string, 0, len(sections))
+	for name := range sections {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var providers []Provider
+	items := make(map[string]string)
+	for _, name := range names {
+		factory, ok := providerFactories[name]
+		if !ok {
+			return nil, nil, fmt.Errorf("unknown provider section %q", name)
+		}
+		var raws []json.RawMessage
+		if err := json.Unmarshal(sections[name], &raws); err != nil {
+			return nil, nil, fmt.Errorf("%s: %w", name, err)
+		}
+		for i, raw := range raws {
+			p, err := factory(raw)
+			if err != nil {
+				return nil, nil, fmt.Errorf("%s item %d: %w", name, i, err)
+			}
+			if err := validateOptions(p.Options()); err != nil {
+				return nil, nil, fmt.Errorf("%s: %w", p.Name(), err)
+			}
+			if err := p.Validate(); err != nil {
+				return nil, nil, fmt.Errorf("%s: %w", p.Name(), err)
+			}
+			if _, ok := items[p.Name()]; ok {
+				return nil, nil, fmt.Errorf("%s: configured more than once", p.Name())
+			}
+			var compacted bytes.Buffer
+			if err := json.Compact(&compacted, raw); err != nil {
+				return nil, nil, fmt.Errorf("%s: %w", p.Name(), err)
+			}
+			if p.Options().LAN != nil {
+				// the records of the hosts are derived from the item
+				if _, err := deriveLANProvider(compacted.String(), p, "host"); err != nil {
+					return nil, nil, fmt.Errorf("%s: %w", p.Name(), err)
+				}
+			}
+			items[p.Name()] = compacted.String()
+			providers = append(providers, p)
+		}
+	}
+	return providers, items, nil
+}
+
+// validateOptions checks the settings shared by all providers.
+func validateOptions(o *models.RecordOptions) error {
+	switch o.Stack {
+	case "", "ipv4", "ipv6", "dual":
+	default:
+		return fmt.Errorf("invalid stack %q, available values: ipv4, ipv6, dual", o.Stack)
+	}
+	if o.StableChecks < 0 {
+		return fmt.Errorf("invalid stable_checks %d", o.StableChecks)
+	}
+	for _, d := range []string{o.StableFor, o.MinInterval} {
+		if _, err := time.ParseDuration(d); len(d) != 0 && err != nil {
+			return err
+		}
+	}
+	if d, err := time.ParseDuration(o.Interval); len(o.Interval) != 0 && (err != nil || d <= 0) {
+		return fmt.Errorf("invalid interval %q", o.Interval)
+	}
+	if o.Quorum < 0 {
+		return fmt.Errorf("invalid quorum %d", o.Quorum)
+	}
+	if len(o.Sources) != 0 {
+		if _, err := newDetectorFromSpecs(o.Sources, o.Quorum); err != nil {
+			return fmt.Errorf("invalid IP sources: %w", err)
+		}
+	}
+	for _, cidr := range o.Allow {
+		if _, err := netip.ParsePrefix(cidr); err != nil {
+			return fmt.Errorf("invalid allowed range: %w", err)
+		}
+	}
+	if _, err := newInterfaceSelector(o.Interface); err != nil {
+		return err
+	}
+	if _, _, _, err := hostSuffix(o); err != nil {
+		return err
+	}
+	if o.LAN != nil {
+		if err := validateLAN(o.LAN); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// publish points the record to value unless the provider reports that it
+// already holds it, the returned result is resultGood or resultNoChange.
+// Each provider call is limited to requestTimeout.
+func publish(ctx context.Context, p Provider, recordType string, value string) (string, error) {
+	lookupCtx, cancel := context.WithTimeout(ctx, requestTimeout)
+	current, err := p.CurrentRecord(lookupCtx, recordType)
+	cancel()
+	if err != nil && !errors.Is(err, errNotSupported) {
+		return "", err
+	}
+	if current != nil && sameAddress(current.Value, value) {
+		return resultNoChange, nil
+	}
+	updateCtx, cancel := context.WithTimeout(ctx, requestTimeout)
+	defer cancel()
+	if err = p.Upsert(updateCtx, current, recordType, value); err != nil {
+		return "", err
+	}
+	return resultGood, nil
+}
+
+// sameAddress compares two IP addresses regardless of their text form.
+func sameAddress(a string, b string) bool {
+	addrA, errA := netip.ParseAddr(a)
+	addrB, errB := netip.ParseAddr(b)
+	if errA != nil || errB != nil {
+		return a == b
+	}
+	return addrA.Unmap() == addrB.Unmap()
+}
+
+func fullDomain(subDomain string, domain string) string {
+	if subDomain == "" || subDomain == "@" {
+		return domain
+	}
+	return subDomain + "." + domain
+}
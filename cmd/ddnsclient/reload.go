@@ -0,0 +1,75 @@
+package main
+
+import (
+	"log"
+	"os"
+	"time"
+)
+
+// configWatcher notices changes of the config file by polling its
+// modification time and size.
+type configWatcher struct {
+	path    string
+	modTime time.Time
+	size    int64
+}
+
+func newConfigWatcher(path string) *configWatcher {
+	w := &configWatcher{path: path}
+	w.changed()
+	return w
+}
+
+// changed reports whether the file differs from the last call, a file that
+// can't be read is treated as unchanged.
+func (w *configWatcher) changed() bool {
+	fi, err := os.Stat(w.path)
+	if err != nil {
+		return false
+	}
+	if fi.ModTime().Equal(w.modTime) && fi.Size() == w.size {
+		return false
+	}
+	w.modTime, w.size = fi.ModTime(), fi.Size()
+	return true
+}
+
+// applySetting switches from the running setting to next and returns the
+// setting to use from now on. Unchanged items keep their running provider
+// and state, updates of removed and changed items are stopped, and the state
+// of removed items is dropped. The host records of removed LAN items are removed.
+func applySetting(running *Setting, next *Setting, sched *scheduler, state *stateStore) *Setting {
+	current := make(map[string]Provider, len(running.Providers))
+	for _, p := range running.Providers {
+		current[p.Name()] = p
+	}
+
+	merged := &Setting{items: next.items}
+	for _, p := range next.Providers {
+		name := p.Name()
+		old, ok := current[name]
+		delete(current, name)
+		switch {
+		case !ok:
+			log.Printf("%s added\n", name)
+		case running.items[name] != next.items[name]:
+			log.Printf("%s changed\n", name)
+			sched.stop(name)
+			lanHosts.forget(name)
+		default:
+			p = old
+		}
+		merged.Providers = append(merged.Providers, p)
+	}
+	for name, p := range current {
+		log.Printf("%s removed\n", name)
+		sched.stop(name)
+		if p.Options().LAN != nil {
+			lanHosts.removeAll(p, running.items[name], sched)
+		}
+		if err := state.forget(name); err != nil {
+			log.Println("saving state failed:", err)
+		}
+	}
+	return merged
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestApplySetting(t *testing.T) {
+	running, err := loadSetting([]byte(`{"dnspod": [
+		{"id": "1", "token": "t", "domain": "example.com", "sub_domain": "www"},
+		{"id": "1", "token": "t", "domain": "example.com", "sub_domain": "home"},
+		{"id": "1", "token": "t", "domain": "example.com", "sub_domain": "old"}
+	]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	next, err := loadSetting([]byte(`{"dnspod": [
+		{"id": "1", "token": "t", "domain": "example.com", "sub_domain": "www"},
+		{"id": "1", "token": "t", "domain": "example.com", "sub_domain": "home", "stack": "dual"},
+		{"id": "1", "token": "t", "domain": "example.com", "sub_domain": "new"}
+	]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sched := newTestScheduler(t, retryPolicy{maxAttempts: 1})
+	for _, p := range running.Providers {
+		sched.state.record(recordKey(p, "A"), "192.0.2.1", resultGood, nil)
+	}
+
+	merged := applySetting(running, next, sched, sched.state)
+
+	if len(merged.Providers) != 3 {
+		t.Fatalf("got %d providers, want 3", len(merged.Providers))
+	}
+	if merged.Providers[0] != running.Providers[0] {
+		t.Error("unchanged item got a new provider")
+	}
+	if merged.Providers[1] != next.Providers[1] {
+		t.Error("changed item kept the old provider")
+	}
+	if merged.Providers[2] != next.Providers[2] {
+		t.Error("added item is missing")
+	}
+	if _, ok := sched.state.get("dnspod:www.example.com/A"); !ok {
+		t.Error("state of unchanged item was dropped")
+	}
+	if _, ok := sched.state.get("dnspod:old.example.com/A"); ok {
+		t.Error("state of removed item was kept")
+	}
+}
+
+func TestApplySettingStopsRemovedItem(t *testing.T) {
+	started, release := make(chan struct{}), make(chan struct{})
+	p := &fakeProvider{upsert: func(value string) error {
+		close(started)
+		<-release
+		return errors.New("connection reset")
+	}}
+	running := &Setting{Providers: []Provider{p}, items: map[string]string{p.Name(): "{}"}}
+	sched := newTestScheduler(t, retryPolicy{maxAttempts: 1})
+	sched.submit(p, map[string]string{"A": "192.0.2.1"}, false)
+	<-started
+
+	applySetting(running, &Setting{items: map[string]string{}}, sched, sched.state)
+	close(release)
+	sched.wait()
+	if st, ok := sched.state.get(recordKey(p, "A")); ok {
+		t.Errorf("stopped update of a removed item wrote its state back: %+v", st)
+	}
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"log"
+	"math/rand/v2"
+	"time"
+)
+
+// retryPolicy retries transient failures with exponential backoff and jitter.
+type retryPolicy struct {
+	maxAttempts int
+	baseDelay   time.Duration
+	maxDelay    time.Duration
+}
+
+var retry = retryPolicy{maxAttempts: 5, baseDelay: 10 * time.Second, maxDelay: 10 * time.Minute}
+
+// do calls fn until it succeeds, fails permanently, runs out of attempts or
+// ctx is done, and returns the last error.
+func (r retryPolicy) do(ctx context.Context, name string, fn func() error) error {
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil {
+			return nil
+		}
+		if isPermanent(err) {
+			log.Printf("%s failed permanently, giving up: %v\n", name, err)
+			return err
+		}
+		if attempt >= r.maxAttempts {
+			log.Printf("%s failed %d times, giving up: %v\n", name, attempt, err)
+			return err
+		}
+		delay := r.backoff(attempt)
+		if d := retryAfter(err); d > delay {
+			delay = d
+		}
+		log.Printf("%s failed (attempt %d/%d), retrying in %v: %v\n", name, attempt, r.maxAttempts, delay, err)
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			log.Printf("%s cancelled: %v\n", name, ctx.Err())
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// backoff returns the delay after the given failed attempt: the base delay
+// doubled per attempt up to the maximum, with the upper half randomized.
+func (r retryPolicy) backoff(attempt int) time.Duration {
+	delay := r.baseDelay
+	for i := 1; i < attempt && delay < r.maxDelay; i++ {
+		delay *= 2
+	}
+	if delay > r.maxDelay {
+		delay = r.maxDelay
+	}
+	if delay <= 1 {
+		return delay
+	}
+	return delay/2 + rand.N(delay/2)
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestBackoff(t *testing.T) {
+	r := retryPolicy{maxAttempts: 10, baseDelay: 10 * time.Second, maxDelay: time.Minute}
+	for _, tc := range []struct {
+		attempt int
+		full    time.Duration
+	}{
+		{1, 10 * time.Second},
+		{2, 20 * time.Second},
+		{3, 40 * time.Second},
+		// capped at maxDelay
+		{4, time.Minute},
+		{10, time.Minute},
+	} {
+		// the upper half is randomized
+		for range 100 {
+			if d := r.backoff(tc.attempt); d < tc.full/2 || d >= tc.full {
+				t.Fatalf("attempt %d: got %v, want [%v, %v)", tc.attempt, d, tc.full/2, tc.full)
+			}
+		}
+	}
+	if d := (retryPolicy{}).backoff(1); d != 0 {
+		t.Errorf("no delay configured: got %v", d)
+	}
+}
+
+func TestRetryDo(t *testing.T) {
+	r := retryPolicy{maxAttempts: 3, baseDelay: time.Millisecond, maxDelay: time.Millisecond}
+	for _, tc := range []struct {
+		name     string
+		errs     []error
+		attempts int
+	}{
+		{"succeeds", nil, 1},
+		{"recovers", []error{transientError(errors.New("503"), 0)}, 2},
+		{"gives up", []error{errors.New("a"), errors.New("b"), errors.New("c"), errors.New("d")}, 3},
+		{"permanent", []error{authError(errors.New("badauth"))}, 1},
+	} {
+		attempts := 0
+		err := r.do(context.Background(), tc.name, func() error {
+			attempts++
+			if attempts <= len(tc.errs) {
+				return tc.errs[attempts-1]
+			}
+			return nil
+		})
+		if attempts != tc.attempts {
+			t.Errorf("%s: %d attempts, want %d", tc.name, attempts, tc.attempts)
+		}
+		if (err == nil) != (attempts > len(tc.errs)) {
+			t.Errorf("%s: got %v", tc.name, err)
+		}
+	}
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"maps"
+	"sync"
+	"time"
+)
+
+// scheduler publishes records in the background, running at most one update
+// per configured item at a time.
+type scheduler struct {
+	state *stateStore
+	retry retryPolicy
+
+	// ctx is the parent of all jobs, cancel aborts them on shutdown
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mu     sync.Mutex
+	jobs   map[string]*recordJob
+	closed bool
+	wg     sync.WaitGroup
+}
+
+// recordJob is the running update of one configured item.
+type recordJob struct {
+	provider Provider
+	values   map[string]string
+	cancel   context.CancelFunc
+	done     chan struct{}
+}
+
+func newScheduler(state *stateStore, retry retryPolicy) *scheduler {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &scheduler{
+		state:  state,
+		retry:  retry,
+		ctx:    ctx,
+		cancel: cancel,
+		jobs:   make(map[string]*recordJob),
+	}
+}
+
+// submit publishes values, a map from record type to address, to the records
+// of p. If an update of p is still running with the same values nothing
+// happens, otherwise the running update is cancelled and the new one starts
+// as soon as it has returned. With force set, records the state store knows
+// to be up to date are checked against the provider again.
+func (s *scheduler) submit(p Provider, values map[string]string, force bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return
+	}
+
+	key := p.Name()
+	prev := s.jobs[key]
+	if prev != nil && prev.provider == p && maps.Equal(prev.values, values) {
+		return
+	}
+	ctx, cancel := context.WithCancel(s.ctx)
+	job := &recordJob{provider: p, values: values, cancel: cancel, done: make(chan struct{})}
+	s.jobs[key] = job
+	if prev != nil {
+		log.Printf("update of %s superseded by %v\n", key, values)
+		prev.cancel()
+	}
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		defer close(job.done)
+		defer cancel()
+		if prev != nil {
+			<-prev.done
+		}
+		s.publish(ctx, p, values, force)
+
+		s.mu.Lock()
+		if s.jobs[key] == job {
+			delete(s.jobs, key)
+		}
+		s.mu.Unlock()
+	}()
+}
+
+// remove deletes the records of the given types of p in the background,
+// after a running update of p has been cancelled, and then forgets their state.
+func (s *scheduler) remove(p Provider, recordTypes []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return
+	}
+
+	key := p.Name()
+	prev := s.jobs[key]
+	ctx, cancel := context.WithCancel(s.ctx)
+	job := &recordJob{provider: p, cancel: cancel, done: make(chan struct{})}
+	s.jobs[key] = job
+	if prev != nil {
+		prev.cancel()
+	}
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		defer close(job.done)
+		defer cancel()
+		if prev != nil {
+			<-prev.done
+		}
+		removed := true
+		for _, recordType := range recordTypes {
+			err := s.retry.do(ctx, "removing "+recordType+" record of "+key, func() error {
+				deleteCtx, cancel := context.WithTimeout(ctx, requestTimeout)
+				defer cancel()
+				err := p.Delete(deleteCtx, recordType)
+				if errors.Is(err, errNotSupported) {
+					return nil
+				}
+				return err
+			})
+			removed = removed && err == nil
+		}
+		if removed {
+			if err := s.state.forget(key); err != nil {
+				log.Println("saving state failed:", err)
+			}
+		}
+
+		s.mu.Lock()
+		if s.jobs[key] == job {
+			delete(s.jobs, key)
+		}
+		s.mu.Unlock()
+	}()
+}
+
+// stop cancels the running update of the item with the given provider name, if any.
+func (s *scheduler) stop(name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if job := s.jobs[name]; job != nil {
+		log.Printf("update of %s stopped\n", name)
+		job.cancel()
+	}
+}
+
+// wait blocks until all submitted updates have returned.
+func (s *scheduler) wait() {
+	s.wg.Wait()
+}
+
+// shutdown stops accepting updates and waits up to timeout for the running
+// ones, which are cancelled after that. It reports whether they all finished in time.
+func (s *scheduler) shutdown(timeout time.Duration) bool {
+	s.mu.Lock()
+	s.closed = true
+	s.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-done:
+		return true
+	case <-timer.C:
+	}
+	s.cancel()
+	<-done
+	return false
+}
+
+// publish updates the records of p one after another, skipping those the
+// state store knows are already up to date.
+func (s *scheduler) publish(ctx context.Context, p Provider, values map[string]string, force bool) {
+	for recordType, newIP := range values {
+		if ctx.Err() != nil {
+			return
+		}
+		key := recordKey(p, recordType)
+		if st, ok := s.state.get(key); ok && !force && st.published(newIP) {
+			log.Printf("%s record of %s is already %s since %v\n", recordType, p.Name(), newIP, st.UpdatedAt)
+			continue
+		}
+		s.retry.do(ctx, recordType+" record of "+p.Name(), func() error {
+			result, err := publish(ctx, p, recordType, newIP)
+			if ctx.Err() != nil {
+				// stopped, the record may have been removed and its state forgotten
+				return err
+			}
+			s.state.record(key, newIP, result, err)
+			if result == resultNoChange {
+				log.Printf("%s record of %s is %s: %s\n", recordType, p.Name(), result, newIP)
+			}
+			return err
+		})
+	}
+}
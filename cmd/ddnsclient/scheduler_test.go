@@ -0,0 +1,199 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/missdeer/ddnsclient/models"
+)
+
+// fakeProvider records the values it's asked to publish.
+type fakeProvider struct {
+	options models.RecordOptions
+	upsert  func(value string) error
+	// current holds the records at the service by type
+	current map[string]string
+
+	running    atomic.Int32
+	maxRunning atomic.Int32
+
+	mu      sync.Mutex
+	upserts []string
+}
+
+func (p *fakeProvider) Name() string                   { return "fake:www.example.com" }
+func (p *fakeProvider) Options() *models.RecordOptions { return &p.options }
+func (p *fakeProvider) Validate() error                { return nil }
+func (p *fakeProvider) Delete(ctx context.Context, recordType string) error {
+	return errNotSupported
+}
+
+func (p *fakeProvider) CurrentRecord(ctx context.Context, recordType string) (*Record, error) {
+	if value, ok := p.current[recordType]; ok {
+		return &Record{ID: "1", Type: recordType, Value: value}, nil
+	}
+	return nil, nil
+}
+
+func (p *fakeProvider) Upsert(ctx context.Context, current *Record, recordType string, value string) error {
+	n := p.running.Add(1)
+	defer p.running.Add(-1)
+	for {
+		max := p.maxRunning.Load()
+		if n <= max || p.maxRunning.CompareAndSwap(max, n) {
+			break
+		}
+	}
+
+	p.mu.Lock()
+	p.upserts = append(p.upserts, value)
+	p.mu.Unlock()
+	if p.upsert != nil {
+		return p.upsert(value)
+	}
+	return nil
+}
+
+func (p *fakeProvider) published() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]string(nil), p.upserts...)
+}
+
+func newTestScheduler(t *testing.T, policy retryPolicy) *scheduler {
+	state, err := loadState("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return newScheduler(state, policy)
+}
+
+func TestSchedulerSingleFlight(t *testing.T) {
+	release := make(chan struct{})
+	started := make(chan string, 10)
+	p := &fakeProvider{upsert: func(value string) error {
+		started <- value
+		<-release
+		return nil
+	}}
+	sched := newTestScheduler(t, retryPolicy{maxAttempts: 1})
+
+	sched.submit(p, map[string]string{"A": "192.0.2.1"}, false)
+	if v := <-started; v != "192.0.2.1" {
+		t.Fatalf("first update published %s", v)
+	}
+	// a newer address supersedes the running update, repeating it is a no-op
+	sched.submit(p, map[string]string{"A": "192.0.2.2"}, false)
+	sched.submit(p, map[string]string{"A": "192.0.2.2"}, false)
+	close(release)
+	sched.wait()
+
+	if got := p.published(); len(got) != 2 || got[0] != "192.0.2.1" || got[1] != "192.0.2.2" {
+		t.Fatalf("published %v, want [192.0.2.1 192.0.2.2]", got)
+	}
+	if max := p.maxRunning.Load(); max != 1 {
+		t.Fatalf("%d updates of the same record ran concurrently", max)
+	}
+	if st, _ := sched.state.get(recordKey(p, "A")); st.Value != "192.0.2.2" || st.Result != resultGood {
+		t.Fatalf("state is %+v", st)
+	}
+}
+
+func TestSchedulerCancelsSupersededRetries(t *testing.T) {
+	failed := make(chan struct{}, 10)
+	p := &fakeProvider{upsert: func(value string) error {
+		if value == "192.0.2.1" {
+			failed <- struct{}{}
+			return errors.New("connection refused")
+		}
+		return nil
+	}}
+	sched := newTestScheduler(t, retryPolicy{maxAttempts: 10, baseDelay: time.Hour, maxDelay: time.Hour})
+
+	sched.submit(p, map[string]string{"A": "192.0.2.1"}, false)
+	<-failed
+	sched.submit(p, map[string]string{"A": "192.0.2.2"}, false)
+
+	done := make(chan struct{})
+	go func() {
+		sched.wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("superseded retry wasn't cancelled")
+	}
+	if got := p.published(); len(got) != 2 || got[1] != "192.0.2.2" {
+		t.Fatalf("published %v, want [192.0.2.1 192.0.2.2]", got)
+	}
+}
+
+func TestUpdateDDNSRetriesFailedRecords(t *testing.T) {
+	o := models.RecordOptions{Stack: "ipv4", Allow: []string{"203.0.113.0/24"}}
+	failures := 2
+	flaky := newNamedProvider("fake:flaky", o)
+	flaky.upsert = func(value string) error {
+		if failures > 0 {
+			failures--
+			return transientError(errors.New("503 Service Unavailable"), 0)
+		}
+		return nil
+	}
+	rejected := newNamedProvider("fake:rejected", o)
+	rejected.upsert = func(value string) error {
+		return authError(errors.New("badauth"))
+	}
+	setting := &Setting{Providers: []Provider{flaky, rejected}}
+	external := newDetectorSet(newDetector([]ipSource{&fixedSource{name: "fixed", addr: "203.0.113.1"}}, 1), 1)
+	sched := newTestScheduler(t, retryPolicy{maxAttempts: 2})
+	clear(currentIPs)
+	clear(lastValues)
+	defer clear(currentIPs)
+	defer clear(lastValues)
+	defer clear(lastChecked)
+
+	// the scheduler gives up after two attempts, the next checks try again
+	for range 3 {
+		updateDDNS(context.Background(), setting, external, sched, false)
+		sched.wait()
+	}
+	if st, _ := sched.state.get(recordKey(flaky, "A")); !st.published("203.0.113.1") {
+		t.Errorf("flaky record state is %+v, want published", st)
+	}
+	if got := flaky.published(); len(got) != 3 {
+		t.Errorf("flaky record attempted %d times, want 3", len(got))
+	}
+	// a rejected record isn't retried until its address changes
+	if got := rejected.published(); len(got) != 1 {
+		t.Errorf("rejected record attempted %d times, want once", len(got))
+	}
+}
+
+func TestSchedulerNoChange(t *testing.T) {
+	p := &fakeProvider{current: map[string]string{"A": "192.0.2.1", "AAAA": "2001:0db8::0001"}}
+	sched := newTestScheduler(t, retryPolicy{maxAttempts: 1})
+
+	sched.submit(p, map[string]string{"A": "192.0.2.1", "AAAA": "2001:db8::1"}, false)
+	sched.wait()
+	if got := p.published(); len(got) != 0 {
+		t.Errorf("records matching the service were written: %v", got)
+	}
+	for _, recordType := range []string{"A", "AAAA"} {
+		if st, _ := sched.state.get(recordKey(p, recordType)); st.Result != resultNoChange {
+			t.Errorf("%s record state is %+v, want %s", recordType, st, resultNoChange)
+		}
+	}
+
+	// a different address is written
+	p.current["A"] = "192.0.2.9"
+	sched.submit(p, map[string]string{"A": "192.0.2.2"}, false)
+	sched.wait()
+	if got := p.published(); len(got) != 1 || got[0] != "192.0.2.2" {
+		t.Errorf("published %v, want 192.0.2.2", got)
+	}
+}
@@ -0,0 +1,17 @@
+//go:build !windows
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+var (
+	// stopSignals shut the daemon down gracefully
+	stopSignals = []os.Signal{os.Interrupt, syscall.SIGTERM}
+	// reloadSignals reload the config file
+	reloadSignals = []os.Signal{syscall.SIGHUP}
+	// updateSignals force an update right now
+	updateSignals = []os.Signal{syscall.SIGUSR1}
+)
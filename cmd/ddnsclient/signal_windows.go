@@ -0,0 +1,14 @@
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+var (
+	// stopSignals shut the daemon down gracefully
+	stopSignals = []os.Signal{os.Interrupt, syscall.SIGTERM}
+	// reloadSignals and updateSignals have no equivalent on Windows
+	reloadSignals []os.Signal
+	updateSignals []os.Signal
+)
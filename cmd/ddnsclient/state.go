@@ -0,0 +1,144 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// provider results stored in the state file, anything else is an error message
+const (
+	resultGood     = "good"
+	resultNoChange = "nochg"
+)
+
+// recordState is the last value published for a record.
+type recordState struct {
+	Value     string    `json:"value"`
+	UpdatedAt time.Time `json:"updated_at"`
+	Result    string    `json:"result"`
+	// Permanent is set if the last attempt failed with an error which
+	// retrying doesn't fix, like a rejected authorization.
+	Permanent bool `json:"permanent,omitempty"`
+}
+
+// published reports whether value is already known to be the record's content.
+func (s recordState) published(value string) bool {
+	return s.Value == value && (s.Result == resultGood || s.Result == resultNoChange)
+}
+
+// failed reports whether the last attempt failed and may succeed if retried.
+func (s recordState) failed() bool {
+	return s.Result != resultGood && s.Result != resultNoChange && !s.Permanent
+}
+
+// stateStore keeps the recordState of every record keyed by recordKey, and
+// writes it to a JSON file on each change so it survives restarts.
+type stateStore struct {
+	mu      sync.Mutex
+	path    string
+	records map[string]recordState
+}
+
+// loadState reads the state file at path, a missing file gives an empty store.
+// An empty path keeps the state in memory only. The store is usable even if
+// the file can't be read, it's empty then and the error is returned too.
+func loadState(path string) (*stateStore, error) {
+	s := &stateStore{path: path, records: make(map[string]recordState)}
+	if len(path) == 0 {
+		return s, nil
+	}
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return s, err
+	}
+	if err = json.Unmarshal(b, &s.records); err != nil {
+		s.records = make(map[string]recordState)
+		return s, err
+	}
+	return s, nil
+}
+
+func recordKey(p Provider, recordType string) string {
+	return p.Name() + "/" + recordType
+}
+
+func (s *stateStore) get(key string) (recordState, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	st, ok := s.records[key]
+	return st, ok
+}
+
+func (s *stateStore) set(key string, st recordState) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.records[key] = st
+	return s.save()
+}
+
+// record stores the outcome of publishing value, a failure keeps the last published value.
+func (s *stateStore) record(key string, value string, result string, err error) {
+	st, _ := s.get(key)
+	if err != nil {
+		st.Result, st.Permanent = err.Error(), isPermanent(err)
+	} else {
+		st = recordState{Value: value, UpdatedAt: time.Now(), Result: result}
+	}
+	if err = s.set(key, st); err != nil {
+		log.Println("saving state failed:", err)
+	}
+}
+
+// forget removes the state of all records of the provider with the given name.
+func (s *stateStore) forget(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for key := range s.records {
+		if strings.HasPrefix(key, name+"/") {
+			delete(s.records, key)
+		}
+	}
+	return s.save()
+}
+
+// flush writes the state file.
+func (s *stateStore) flush() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.save()
+}
+
+// save writes the state to a temporary file and renames it over the state
+// file, so a crash never leaves a truncated file behind. Callers hold mu.
+func (s *stateStore) save() error {
+	if len(s.path) == 0 {
+		return nil
+	}
+	b, err := json.MarshalIndent(s.records, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(s.path), filepath.Base(s.path)+".*")
+	if err != nil {
+		return err
+	}
+	if _, err = tmp.Write(b); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), s.path)
+}
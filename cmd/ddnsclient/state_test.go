@@ -0,0 +1,62 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestStateSaveLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	state, err := loadState(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	state.record("dnspod:home.example.com/A", "192.0.2.1", resultGood, nil)
+	state.record("dnspod:home.example.com/AAAA", "2001:db8::1", resultNoChange, nil)
+	state.record("dnspod:home.example.com/AAAA", "2001:db8::2", "", authError(errors.New("badauth")))
+	state.record("dnspod:old.example.com/A", "192.0.2.9", resultGood, nil)
+	if err = state.forget("dnspod:old.example.com"); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := loadState(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st, _ := loaded.get("dnspod:home.example.com/A"); !st.published("192.0.2.1") || st.UpdatedAt.IsZero() {
+		t.Errorf("A record loaded as %+v", st)
+	}
+	// a failure keeps the published value and remembers it's permanent
+	if st, _ := loaded.get("dnspod:home.example.com/AAAA"); st.Value != "2001:db8::1" || st.Result != "auth error: badauth" || !st.Permanent {
+		t.Errorf("AAAA record loaded as %+v", st)
+	}
+	if _, ok := loaded.get("dnspod:old.example.com/A"); ok {
+		t.Error("forgotten record loaded")
+	}
+	if matches, _ := filepath.Glob(path + ".*"); len(matches) != 0 {
+		t.Errorf("temporary files left behind: %v", matches)
+	}
+}
+
+func TestLoadCorruptState(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	if err := os.WriteFile(path, []byte(`{"dnspod:home.example.com/A": {"value": "192.0.2.1"`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	state, err := loadState(path)
+	if err == nil {
+		t.Fatal("corrupt state file loaded without an error")
+	}
+	if state == nil || len(state.records) != 0 {
+		t.Fatalf("got %v, want an empty store", state)
+	}
+	// the next write replaces the corrupt file
+	state.record("dnspod:home.example.com/A", "192.0.2.2", resultGood, nil)
+	if loaded, err := loadState(path); err != nil {
+		t.Error(err)
+	} else if st, _ := loaded.get("dnspod:home.example.com/A"); st.Value != "192.0.2.2" {
+		t.Errorf("loaded %+v", st)
+	}
+}
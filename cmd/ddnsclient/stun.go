@@ -0,0 +1,197 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"strings"
+	"time"
+)
+
+// STUN message constants, see RFC 5389
+const (
+	stunBindingRequest   = 0x0001
+	stunBindingSuccess   = 0x0101
+	stunMagicCookie      = 0x2112A442
+	stunHeaderSize       = 20
+	stunMappedAddress    = 0x0001
+	stunXorMappedAddress = 0x0020
+	stunDefaultPort      = "3478"
+	stunInitialRTO       = 500 * time.Millisecond
+	stunMaxRequests      = 4
+)
+
+var errSTUNTransaction = errors.New("STUN response for another transaction")
+
+func init() {
+	registerIPSource("stun", newSTUNSource)
+}
+
+// stunSource learns the external address from the XOR-MAPPED-ADDRESS a STUN
+// server returns for a Binding Request, e.g. "stun:stun.l.google.com:19302".
+type stunSource struct {
+	server string
+}
+
+func newSTUNSource(spec string) (ipSource, error) {
+	server := strings.TrimPrefix(strings.TrimPrefix(spec, "stun:"), "//")
+	if len(server) == 0 {
+		return nil, fmt.Errorf("missing STUN server in %q", spec)
+	}
+	if _, _, err := net.SplitHostPort(server); err != nil {
+		server = net.JoinHostPort(strings.Trim(server, "[]"), stunDefaultPort)
+	}
+	return &stunSource{server: server}, nil
+}
+
+func (s *stunSource) Name() string {
+	return "stun:" + s.server
+}
+
+func (s *stunSource) Detect(ctx context.Context, ipv4 bool) (netip.Addr, error) {
+	network := "udp6"
+	if ipv4 {
+		network = "udp4"
+	}
+	conn, err := dialContext(ctx, network, s.server)
+	if err != nil {
+		return netip.Addr{}, err
+	}
+	defer conn.Close()
+	// unblock the read below as soon as ctx is done
+	stop := context.AfterFunc(ctx, func() {
+		conn.SetReadDeadline(time.Now())
+	})
+	defer stop()
+
+	req, txid, err := newSTUNBindingRequest()
+	if err != nil {
+		return netip.Addr{}, err
+	}
+	buf := make([]byte, 1500)
+	rto := stunInitialRTO
+	for i := 0; i < stunMaxRequests; i++ {
+		if _, err = conn.Write(req); err != nil {
+			return netip.Addr{}, err
+		}
+		conn.SetReadDeadline(time.Now().Add(rto))
+		rto *= 2
+		for {
+			var n int
+			n, err = conn.Read(buf)
+			if err != nil {
+				break
+			}
+			var addr netip.Addr
+			addr, err = parseSTUNBindingResponse(buf[:n], txid)
+			if errors.Is(err, errSTUNTransaction) {
+				// a late answer to an earlier request, keep waiting
+				continue
+			}
+			return addr, err
+		}
+		if ctx.Err() != nil {
+			return netip.Addr{}, ctx.Err()
+		}
+		var netErr net.Error
+		if !errors.As(err, &netErr) || !netErr.Timeout() {
+			return netip.Addr{}, err
+		}
+	}
+	return netip.Addr{}, fmt.Errorf("no response from STUN server %s", s.server)
+}
+
+// newSTUNBindingRequest returns a Binding Request without attributes and its transaction ID.
+func newSTUNBindingRequest() ([]byte, []byte, error) {
+	req := make([]byte, stunHeaderSize)
+	binary.BigEndian.PutUint16(req[0:], stunBindingRequest)
+	binary.BigEndian.PutUint16(req[2:], 0)
+	binary.BigEndian.PutUint32(req[4:], stunMagicCookie)
+	if _, err := rand.Read(req[8:stunHeaderSize]); err != nil {
+		return nil, nil, err
+	}
+	return req, req[8:stunHeaderSize], nil
+}
+
+// parseSTUNBindingResponse returns the XOR-MAPPED-ADDRESS of a Binding
+// Success Response, or the MAPPED-ADDRESS of servers predating RFC 5389.
+func parseSTUNBindingResponse(msg []byte, txid []byte) (netip.Addr, error) {
+	if len(msg) < stunHeaderSize {
+		return netip.Addr{}, errors.New("short STUN message")
+	}
+	if binary.BigEndian.Uint32(msg[4:]) != stunMagicCookie {
+		return netip.Addr{}, errors.New("not a STUN message")
+	}
+	if string(msg[8:stunHeaderSize]) != string(txid) {
+		return netip.Addr{}, errSTUNTransaction
+	}
+	if t := binary.BigEndian.Uint16(msg[0:]); t != stunBindingSuccess {
+		return netip.Addr{}, fmt.Errorf("STUN Binding Request failed with message type %#04x", t)
+	}
+	length := int(binary.BigEndian.Uint16(msg[2:]))
+	if stunHeaderSize+length > len(msg) {
+		return netip.Addr{}, errors.New("truncated STUN message")
+	}
+
+	var mapped netip.Addr
+	attrs := msg[stunHeaderSize : stunHeaderSize+length]
+	for len(attrs) >= 4 {
+		attrType := binary.BigEndian.Uint16(attrs[0:])
+		attrLen := int(binary.BigEndian.Uint16(attrs[2:]))
+		if 4+attrLen > len(attrs) {
+			return netip.Addr{}, errors.New("truncated STUN attribute")
+		}
+		value := attrs[4 : 4+attrLen]
+		switch attrType {
+		case stunXorMappedAddress:
+			return parseSTUNAddress(value, msg[4:stunHeaderSize])
+		case stunMappedAddress:
+			if addr, err := parseSTUNAddress(value, nil); err == nil {
+				mapped = addr
+			}
+		}
+		// attributes are padded to a multiple of 4 bytes
+		padded := (4 + attrLen + 3) &^ 3
+		if padded > len(attrs) {
+			break
+		}
+		attrs = attrs[padded:]
+	}
+	if mapped.IsValid() {
+		return mapped, nil
+	}
+	return netip.Addr{}, errors.New("no mapped address in STUN response")
+}
+
+// parseSTUNAddress decodes a (XOR-)MAPPED-ADDRESS value, xor is the magic
+// cookie followed by the transaction ID, or nil for a plain MAPPED-ADDRESS.
+func parseSTUNAddress(value []byte, xor []byte) (netip.Addr, error) {
+	if len(value) < 4 {
+		return netip.Addr{}, errors.New("short STUN address")
+	}
+	var size int
+	switch value[1] {
+	case 0x01:
+		size = 4
+	case 0x02:
+		size = 16
+	default:
+		return netip.Addr{}, fmt.Errorf("unknown STUN address family %#02x", value[1])
+	}
+	if len(value) < 4+size {
+		return netip.Addr{}, errors.New("short STUN address")
+	}
+	ip := make([]byte, size)
+	copy(ip, value[4:4+size])
+	for i := range xor {
+		if i < size {
+			ip[i] ^= xor[i]
+		}
+	}
+	addr, _ := netip.AddrFromSlice(ip)
+	return addr, nil
+}
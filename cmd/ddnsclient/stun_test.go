@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+)
+
+// serveSTUN answers every Binding Request on conn with a XOR-MAPPED-ADDRESS of mapped.
+func serveSTUN(conn net.PacketConn, mapped netip.AddrPort) {
+	buf := make([]byte, 1500)
+	for {
+		n, from, err := conn.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		if n < 20 || binary.BigEndian.Uint16(buf) != 0x0001 {
+			continue
+		}
+		ip := mapped.Addr().AsSlice()
+		family := byte(0x01)
+		if len(ip) == 16 {
+			family = 0x02
+		}
+		// cookie followed by transaction ID, as in the request
+		xor := buf[4:20]
+		value := []byte{0, family, 0, 0}
+		binary.BigEndian.PutUint16(value[2:], mapped.Port()^0x2112)
+		for i := range ip {
+			value = append(value, ip[i]^xor[i])
+		}
+
+		resp := make([]byte, 20, 20+4+len(value))
+		binary.BigEndian.PutUint16(resp[0:], 0x0101)
+		binary.BigEndian.PutUint16(resp[2:], uint16(4+len(value)))
+		copy(resp[4:20], buf[4:20])
+		resp = binary.BigEndian.AppendUint16(resp, 0x0020)
+		resp = binary.BigEndian.AppendUint16(resp, uint16(len(value)))
+		resp = append(resp, value...)
+		conn.WriteTo(resp, from)
+	}
+}
+
+func TestSTUNSource(t *testing.T) {
+	for _, tc := range []struct {
+		network string
+		listen  string
+		mapped  string
+		ipv4    bool
+	}{
+		{"udp4", "127.0.0.1:0", "203.0.113.5:4242", true},
+		{"udp6", "[::1]:0", "[2001:db8::5]:4242", false},
+	} {
+		conn, err := net.ListenPacket(tc.network, tc.listen)
+		if err != nil {
+			t.Logf("skipping %s: %v", tc.network, err)
+			continue
+		}
+		defer conn.Close()
+		mapped := netip.MustParseAddrPort(tc.mapped)
+		go serveSTUN(conn, mapped)
+
+		src, err := newIPSource("stun:" + conn.LocalAddr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		addr, err := src.Detect(ctx, tc.ipv4)
+		cancel()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if addr != mapped.Addr() {
+			t.Fatalf("got %s, want %s", addr, mapped.Addr())
+		}
+	}
+}
+
+func TestSTUNSourceTimeout(t *testing.T) {
+	// a server that never answers
+	conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	src, _ := newIPSource("stun:" + conn.LocalAddr().String())
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	if addr, err := src.Detect(ctx, true); err == nil {
+		t.Fatalf("got %s from a silent server", addr)
+	}
+}
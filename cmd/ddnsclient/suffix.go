@@ -0,0 +1,76 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+
+	"github.com/missdeer/ddnsclient/models"
+)
+
+// defaultPrefixLength is the prefix length of a LAN subnet out of a delegated prefix.
+const defaultPrefixLength = 64
+
+// hostSuffix returns the suffix of the host the AAAA record of o points to
+// and the length of the prefix it's combined with, or false if o publishes
+// an address of this host.
+func hostSuffix(o *models.RecordOptions) (netip.Addr, int, bool, error) {
+	bits := o.PrefixLength
+	if bits == 0 {
+		bits = defaultPrefixLength
+	}
+	if bits < 1 || bits > 127 {
+		return netip.Addr{}, 0, false, fmt.Errorf("invalid prefix_length %d", o.PrefixLength)
+	}
+	switch {
+	case len(o.Suffix) != 0 && len(o.MAC) != 0:
+		return netip.Addr{}, 0, false, errors.New("suffix and mac can't be used together")
+	case len(o.Suffix) != 0:
+		suffix, err := netip.ParseAddr(o.Suffix)
+		if err != nil || !suffix.Is6() || suffix.Is4In6() {
+			return netip.Addr{}, 0, false, fmt.Errorf("invalid IPv6 suffix %q", o.Suffix)
+		}
+		return suffix, bits, true, nil
+	case len(o.MAC) != 0:
+		suffix, err := eui64(o.MAC)
+		if err != nil {
+			return netip.Addr{}, 0, false, err
+		}
+		if bits > 64 {
+			return netip.Addr{}, 0, false, fmt.Errorf("an EUI-64 interface identifier needs a prefix_length of 64 or less, not %d", bits)
+		}
+		return suffix, bits, true, nil
+	}
+	return netip.Addr{}, 0, false, nil
+}
+
+// eui64 returns the modified EUI-64 interface identifier (RFC 4291) of a
+// 48 bit MAC address as the lower half of an IPv6 address.
+func eui64(mac string) (netip.Addr, error) {
+	hw, err := net.ParseMAC(mac)
+	if err != nil || len(hw) != 6 {
+		return netip.Addr{}, fmt.Errorf("invalid MAC address %q", mac)
+	}
+	var b [16]byte
+	b[8], b[9], b[10] = hw[0]^0x02, hw[1], hw[2]
+	b[11], b[12] = 0xff, 0xfe
+	b[13], b[14], b[15] = hw[3], hw[4], hw[5]
+	return netip.AddrFrom16(b), nil
+}
+
+// withSuffix returns the first bits of prefix followed by the rest of suffix.
+func withSuffix(prefix netip.Addr, bits int, suffix netip.Addr) netip.Addr {
+	p, s := prefix.As16(), suffix.As16()
+	for i := range p {
+		mask := byte(0xff)
+		switch {
+		case bits >= (i+1)*8:
+			continue
+		case bits > i*8:
+			mask = 0xff >> (bits - i*8)
+		}
+		p[i] = p[i]&^mask | s[i]&mask
+	}
+	return netip.AddrFrom16(p)
+}
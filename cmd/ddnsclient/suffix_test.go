@@ -0,0 +1,57 @@
+package main
+
+import (
+	"net/netip"
+	"testing"
+
+	"github.com/missdeer/ddnsclient/models"
+)
+
+func TestWithSuffix(t *testing.T) {
+	for _, tc := range []struct {
+		prefix string
+		bits   int
+		suffix string
+		want   string
+	}{
+		{"2001:db8:1:2:aaaa::1", 64, "::1234", "2001:db8:1:2::1234"},
+		{"2001:db8:1:2ff::1", 56, "0:0:0:ab::1234", "2001:db8:1:2ab::1234"},
+		{"2001:db8:1:2::1", 60, "::1", "2001:db8:1::1"},
+	} {
+		got := withSuffix(netip.MustParseAddr(tc.prefix), tc.bits, netip.MustParseAddr(tc.suffix))
+		if got.String() != tc.want {
+			t.Errorf("%s/%d + %s: got %s, want %s", tc.prefix, tc.bits, tc.suffix, got, tc.want)
+		}
+	}
+}
+
+func TestHostSuffix(t *testing.T) {
+	suffix, bits, ok, err := hostSuffix(&models.RecordOptions{MAC: "00:11:22:33:44:55"})
+	if err != nil || !ok || bits != 64 || suffix.String() != "::211:22ff:fe33:4455" {
+		t.Errorf("EUI-64: got %s/%d %v %v", suffix, bits, ok, err)
+	}
+	if _, _, ok, err := hostSuffix(&models.RecordOptions{}); ok || err != nil {
+		t.Errorf("record without suffix: %v %v", ok, err)
+	}
+	for _, o := range []models.RecordOptions{
+		{Suffix: "1.2.3.4"},
+		{Suffix: "::1", MAC: "00:11:22:33:44:55"},
+		{MAC: "00:11:22:33:44:55:66:77"},
+		{MAC: "00:11:22:33:44:55", PrefixLength: 72},
+		{Suffix: "::1", PrefixLength: 128},
+	} {
+		if _, _, _, err := hostSuffix(&o); err == nil {
+			t.Errorf("%+v accepted", o)
+		}
+	}
+
+	// LAN hosts get their own AAAA address but share the A record address
+	host := &models.RecordOptions{Suffix: "::10"}
+	other := &models.RecordOptions{Suffix: "::20"}
+	if addressKey(host, false) == addressKey(other, false) {
+		t.Error("hosts with different suffixes share an address")
+	}
+	if addressKey(host, true) != addressKey(&models.RecordOptions{}, true) {
+		t.Error("suffix changed the A record")
+	}
+}
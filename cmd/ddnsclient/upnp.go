@@ -0,0 +1,240 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/missdeer/ddnsclient/models"
+)
+
+const (
+	ssdpMulticastAddress = "239.255.255.250:1900"
+	ssdpSearchTimeout    = 2 * time.Second
+)
+
+// upnpSearchTargets are the device types an Internet Gateway Device answers to.
+var upnpSearchTargets = []string{
+	"urn:schemas-upnp-org:device:InternetGatewayDevice:1",
+	"urn:schemas-upnp-org:device:InternetGatewayDevice:2",
+}
+
+func init() {
+	registerIPSource("upnp", newUPnPSource)
+}
+
+// upnpSource asks the Internet Gateway Device for its WAN address. "upnp:"
+// discovers the gateway by SSDP multicast, "upnp://192.168.1.1:1900" sends
+// the search to the given address only. The gateway only knows IPv4.
+type upnpSource struct {
+	ssdpAddress string
+
+	mu         sync.Mutex
+	controlURL string
+	service    string
+}
+
+func newUPnPSource(spec string) (ipSource, error) {
+	s := &upnpSource{ssdpAddress: ssdpMulticastAddress}
+	if target := strings.TrimPrefix(spec, "upnp:"); len(target) != 0 {
+		u, err := url.Parse(spec)
+		if err != nil {
+			return nil, err
+		}
+		if len(u.Host) == 0 {
+			return nil, fmt.Errorf("UPnP source %q should look like upnp: or upnp://host:port", spec)
+		}
+		s.ssdpAddress = u.Host
+		if len(u.Port()) == 0 {
+			s.ssdpAddress = net.JoinHostPort(u.Hostname(), "1900")
+		}
+	}
+	return s, nil
+}
+
+func (s *upnpSource) Name() string {
+	if s.ssdpAddress == ssdpMulticastAddress {
+		return "upnp:"
+	}
+	return "upnp://" + s.ssdpAddress
+}
+
+func (s *upnpSource) Detect(ctx context.Context, ipv4 bool) (netip.Addr, error) {
+	if !ipv4 {
+		return netip.Addr{}, errors.New("UPnP IGD only reports an IPv4 address")
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.controlURL) == 0 {
+		location, err := s.discover(ctx)
+		if err != nil {
+			return netip.Addr{}, err
+		}
+		if s.controlURL, s.service, err = upnpFindWANService(ctx, location); err != nil {
+			return netip.Addr{}, err
+		}
+	}
+	addr, err := upnpGetExternalIPAddress(ctx, s.controlURL, s.service)
+	if err != nil {
+		// the gateway may have restarted with another URL, discover it again next time
+		s.controlURL, s.service = "", ""
+	}
+	return addr, err
+}
+
+// discover sends SSDP M-SEARCH requests and returns the description
+// location of the first gateway answering.
+func (s *upnpSource) discover(ctx context.Context) (string, error) {
+	raddr, err := net.ResolveUDPAddr("udp4", s.ssdpAddress)
+	if err != nil {
+		return "", err
+	}
+	conn, err := listenPacket(ctx, "udp4", ":0")
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	stop := context.AfterFunc(ctx, func() {
+		conn.SetReadDeadline(time.Now())
+	})
+	defer stop()
+
+	for _, st := range upnpSearchTargets {
+		req := "M-SEARCH * HTTP/1.1\r\n" +
+			"HOST: " + ssdpMulticastAddress + "\r\n" +
+			"MAN: \"ssdp:discover\"\r\n" +
+			fmt.Sprintf("MX: %d\r\n", int(ssdpSearchTimeout/time.Second)) +
+			"ST: " + st + "\r\n\r\n"
+		if _, err = conn.WriteTo([]byte(req), raddr); err != nil {
+			return "", err
+		}
+	}
+
+	conn.SetReadDeadline(time.Now().Add(ssdpSearchTimeout))
+	buf := make([]byte, 2048)
+	for {
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			if ctx.Err() != nil {
+				return "", ctx.Err()
+			}
+			return "", fmt.Errorf("no UPnP Internet Gateway Device found: %w", err)
+		}
+		resp, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(buf[:n])), nil)
+		if err != nil {
+			continue
+		}
+		resp.Body.Close()
+		if location := resp.Header.Get("Location"); resp.StatusCode == http.StatusOK && len(location) != 0 {
+			return location, nil
+		}
+	}
+}
+
+// upnpFindWANService reads the device description at location and returns the
+// control URL and service type of its WANIPConnection or WANPPPConnection service.
+func upnpFindWANService(ctx context.Context, location string) (string, string, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", location, nil)
+	if err != nil {
+		return "", "", err
+	}
+	client := &http.Client{Transport: &http.Transport{DialContext: dialContext}}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", "", err
+	}
+	defer resp.Body.Close()
+	if err = checkResponse(resp); err != nil {
+		return "", "", err
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", "", err
+	}
+
+	root := new(models.UPnPRootDescription)
+	if err = xml.Unmarshal(body, root); err != nil {
+		return "", "", fmt.Errorf("unmarshalling UPnP description %s failed: %w", location, err)
+	}
+	service := upnpFindService(&root.Device)
+	if service == nil {
+		return "", "", fmt.Errorf("no WAN connection service in UPnP description %s", location)
+	}
+
+	base, err := url.Parse(location)
+	if err != nil {
+		return "", "", err
+	}
+	if len(root.URLBase) != 0 {
+		if base, err = url.Parse(root.URLBase); err != nil {
+			return "", "", err
+		}
+	}
+	control, err := base.Parse(service.ControlURL)
+	if err != nil {
+		return "", "", err
+	}
+	return control.String(), service.ServiceType, nil
+}
+
+func upnpFindService(device *models.UPnPDevice) *models.UPnPService {
+	for i, service := range device.Services {
+		if strings.Contains(service.ServiceType, ":WANIPConnection:") || strings.Contains(service.ServiceType, ":WANPPPConnection:") {
+			return &device.Services[i]
+		}
+	}
+	for i := range device.Devices {
+		if service := upnpFindService(&device.Devices[i]); service != nil {
+			return service
+		}
+	}
+	return nil
+}
+
+// upnpGetExternalIPAddress invokes the GetExternalIPAddress action of the service.
+func upnpGetExternalIPAddress(ctx context.Context, controlURL string, service string) (netip.Addr, error) {
+	body := `<?xml version="1.0"?>` +
+		`<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/" s:encodingStyle="http://schemas.xmlsoap.org/soap/encoding/">` +
+		`<s:Body><u:GetExternalIPAddress xmlns:u="` + service + `"/></s:Body></s:Envelope>`
+	req, err := http.NewRequestWithContext(ctx, "POST", controlURL, strings.NewReader(body))
+	if err != nil {
+		return netip.Addr{}, err
+	}
+	req.Header.Set("Content-Type", `text/xml; charset="utf-8"`)
+	req.Header.Set("SOAPAction", `"`+service+`#GetExternalIPAddress"`)
+
+	client := &http.Client{Transport: &http.Transport{DialContext: dialContext}}
+	resp, err := client.Do(req)
+	if err != nil {
+		return netip.Addr{}, err
+	}
+	defer resp.Body.Close()
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return netip.Addr{}, err
+	}
+
+	envelope := new(models.UPnPExternalIPAddressEnvelope)
+	if err = xml.Unmarshal(respBody, envelope); err != nil {
+		if err := checkResponse(resp); err != nil {
+			return netip.Addr{}, err
+		}
+		return netip.Addr{}, fmt.Errorf("unmarshalling UPnP response %s failed: %w", string(respBody), err)
+	}
+	if fault := envelope.Body.Fault; fault != nil {
+		return netip.Addr{}, fmt.Errorf("UPnP GetExternalIPAddress failed: %s %s", fault.FaultString, fault.Description)
+	}
+	return netip.ParseAddr(strings.TrimSpace(envelope.Body.Response.ExternalIPAddress))
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testUPnPDescription = `<?xml version="1.0"?>
+<root xmlns="urn:schemas-upnp-org:device-1-0">
+  <device>
+    <deviceType>urn:schemas-upnp-org:device:InternetGatewayDevice:1</deviceType>
+    <deviceList>
+      <device>
+        <deviceType>urn:schemas-upnp-org:device:WANDevice:1</deviceType>
+        <deviceList>
+          <device>
+            <deviceType>urn:schemas-upnp-org:device:WANConnectionDevice:1</deviceType>
+            <serviceList>
+              <service>
+                <serviceType>urn:schemas-upnp-org:service:WANIPConnection:1</serviceType>
+                <controlURL>/ctl/IPConn</controlURL>
+              </service>
+            </serviceList>
+          </device>
+        </deviceList>
+      </device>
+    </deviceList>
+  </device>
+</root>`
+
+const testUPnPResponse = `<?xml version="1.0"?>
+<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/" s:encodingStyle="http://schemas.xmlsoap.org/soap/encoding/">
+  <s:Body>
+    <u:GetExternalIPAddressResponse xmlns:u="urn:schemas-upnp-org:service:WANIPConnection:1">
+      <NewExternalIPAddress>203.0.113.9</NewExternalIPAddress>
+    </u:GetExternalIPAddressResponse>
+  </s:Body>
+</s:Envelope>`
+
+func TestUPnPSource(t *testing.T) {
+	gateway := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/rootDesc.xml":
+			fmt.Fprint(w, testUPnPDescription)
+		case "/ctl/IPConn":
+			body, _ := ioutil.ReadAll(r.Body)
+			if r.Header.Get("SOAPAction") != `"urn:schemas-upnp-org:service:WANIPConnection:1#GetExternalIPAddress"` ||
+				!strings.Contains(string(body), "GetExternalIPAddress") {
+				http.Error(w, "unexpected action", http.StatusInternalServerError)
+				return
+			}
+			fmt.Fprint(w, testUPnPResponse)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer gateway.Close()
+
+	ssdp, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ssdp.Close()
+	go func() {
+		buf := make([]byte, 1500)
+		for {
+			n, from, err := ssdp.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			if !strings.HasPrefix(string(buf[:n]), "M-SEARCH * HTTP/1.1") {
+				continue
+			}
+			resp := "HTTP/1.1 200 OK\r\n" +
+				"CACHE-CONTROL: max-age=120\r\n" +
+				"ST: urn:schemas-upnp-org:device:InternetGatewayDevice:1\r\n" +
+				"LOCATION: " + gateway.URL + "/rootDesc.xml\r\n\r\n"
+			ssdp.WriteTo([]byte(resp), from)
+		}
+	}()
+
+	src, err := newIPSource("upnp://" + ssdp.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	// the second call uses the cached control URL
+	for i := 0; i < 2; i++ {
+		addr, err := src.Detect(ctx, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if addr.String() != "203.0.113.9" {
+			t.Fatalf("got %s, want 203.0.113.9", addr)
+		}
+	}
+	if _, err = src.Detect(ctx, false); err == nil {
+		t.Fatal("got an IPv6 address from UPnP")
+	}
+}
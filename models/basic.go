@@ -4,5 +4,5 @@ type BasicAuthConfigurationItem struct {
 	UserName string `json:"username"`
 	Password string `json:"password"`
 	Url      string `json:"url"`
-	Internal bool   `json:",omitempty"`
+	RecordOptions
 }
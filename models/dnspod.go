@@ -7,7 +7,16 @@ type DnspodConfigurationItem struct {
 	Password  string `json:"password"`
 	Domain    string `json:"domain"`
 	SubDomain string `json:"sub_domain"`
-	Internal  bool   `json:",omitempty"`
+	RecordOptions
+}
+
+type DnspodStatus struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+type DnspodResponse struct {
+	Status DnspodStatus `json:"status"`
 }
 
 type DnspodDomainItem struct {
@@ -16,14 +25,19 @@ type DnspodDomainItem struct {
 }
 
 type DnspodDomainList struct {
+	Status  DnspodStatus       `json:"status"`
 	Domains []DnspodDomainItem `json:"domains"`
 }
 
 type DnspodRecordItem struct {
-	Id   string `json:"id"`
-	Name string `json:"name"`
+	Id    string `json:"id"`
+	Name  string `json:"name"`
+	Type  string `json:"type"`
+	Value string `json:"value"`
+	Line  string `json:"line"`
 }
 
 type DnspodRecordList struct {
+	Status  DnspodStatus       `json:"status"`
 	Records []DnspodRecordItem `json:"records"`
 }
@@ -0,0 +1,69 @@
+package models
+
+// RecordOptions holds the settings shared by every provider configuration item.
+type RecordOptions struct {
+	Internal bool `json:",omitempty"`
+	// Stack selects the managed records: ipv4 (A), ipv6 (AAAA) or dual (both).
+	// The -stack flag is used when it's empty.
+	Stack string `json:"stack,omitempty"`
+	// Allow lists the CIDR prefixes the published address must be in, it
+	// also allows reserved ranges which are rejected otherwise.
+	Allow []string `json:"allow,omitempty"`
+	// StableChecks and StableFor hold back a new address until it has been
+	// detected by that many consecutive checks and for that long, e.g. "5m".
+	StableChecks int    `json:"stable_checks,omitempty"`
+	StableFor    string `json:"stable_for,omitempty"`
+	// MinInterval is the minimum time between two writes of the record, e.g. "10m".
+	MinInterval string `json:"min_interval,omitempty"`
+	// Sources is a comma separated list of IP sources like the -sources flag
+	// and Quorum how many of them have to agree, the flags are used when empty.
+	Sources string `json:"sources,omitempty"`
+	Quorum  int    `json:"quorum,omitempty"`
+	// Interval is how often the record is checked, e.g. "1h", the -interval
+	// flag is used when it's empty.
+	Interval string `json:"interval,omitempty"`
+	// Bind is the local address or interface name the external address is
+	// detected through, for hosts with several uplinks.
+	Bind string `json:"bind,omitempty"`
+	// Suffix, e.g. "::1234", or the MAC address of a LAN host, for an EUI-64
+	// interface identifier, makes the AAAA record point to that host: its
+	// address is the current global prefix of the local interface selected
+	// by Interface, PrefixLength bits long (64 by default), with the suffix.
+	Suffix       string `json:"suffix,omitempty"`
+	MAC          string `json:"mac,omitempty"`
+	PrefixLength int    `json:"prefix_length,omitempty"`
+	// LAN turns the item into a zone for the hosts of the local network
+	// instead of a record of its own.
+	LAN *LANOptions `json:"lan,omitempty"`
+	// Interface selects the local address published by internal records.
+	Interface *InterfaceFilter `json:"interface,omitempty"`
+}
+
+// InterfaceFilter selects a local interface address.
+type InterfaceFilter struct {
+	// Names are interface names or glob patterns like "eth*", earlier ones
+	// are preferred. Without names, well known virtual interfaces such as
+	// docker bridges and VPN tunnels are skipped.
+	Names []string `json:"names,omitempty"`
+	// Include and Exclude are CIDR prefixes the address must or must not be
+	// in, earlier Include prefixes are preferred.
+	Include []string `json:"include,omitempty"`
+	Exclude []string `json:"exclude,omitempty"`
+}
+
+// LANOptions publishes the hosts of the local network as <host>.<sub_domain>
+// of the item, records of hosts which disappear are removed.
+type LANOptions struct {
+	// Leases are dnsmasq or ISC dhcpd lease files, the hosts are read from
+	// them and their leases must not be expired.
+	Leases []string `json:"leases,omitempty"`
+	// Neighbors adds the addresses found in the neighbor table (ARP/NDP) to
+	// the hosts with the same MAC address, e.g. the IPv6 address of a host
+	// with a DHCPv4 lease.
+	Neighbors bool `json:"neighbors,omitempty"`
+	// Hosts and MACs are glob patterns of the host names and MAC addresses
+	// to publish, a host matching any of them is published. All hosts are
+	// published when both are empty.
+	Hosts []string `json:"hosts,omitempty"`
+	MACs  []string `json:"macs,omitempty"`
+}
@@ -0,0 +1,31 @@
+package models
+
+type UPnPService struct {
+	ServiceType string `xml:"serviceType"`
+	ControlURL  string `xml:"controlURL"`
+}
+
+type UPnPDevice struct {
+	DeviceType string        `xml:"deviceType"`
+	Services   []UPnPService `xml:"serviceList>service"`
+	Devices    []UPnPDevice  `xml:"deviceList>device"`
+}
+
+type UPnPRootDescription struct {
+	URLBase string     `xml:"URLBase"`
+	Device  UPnPDevice `xml:"device"`
+}
+
+type UPnPFault struct {
+	FaultString string `xml:"faultstring"`
+	Description string `xml:"detail>UPnPError>errorDescription"`
+}
+
+type UPnPExternalIPAddressEnvelope struct {
+	Body struct {
+		Response struct {
+			ExternalIPAddress string `xml:"NewExternalIPAddress"`
+		} `xml:"GetExternalIPAddressResponse"`
+		Fault *UPnPFault `xml:"Fault"`
+	} `xml:"Body"`
+}